@@ -0,0 +1,247 @@
+package deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// distributeArtifact builds or downloads the artifact of the deployment on
+// the Applikatoni host and uploads it to all hosts in parallel. The checksum
+// of the uploaded artifact is verified on every host.
+func (m *Manager) distributeArtifact() error {
+	dir, err := ioutil.TempDir("", "applikatoni-artifact")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "artifact.tar.gz")
+
+	err = m.fetchArtifact(file)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	m.logger.LogStageResult(fmt.Sprintf("applikatoni - artifact ready (sha256 %s)", checksum))
+
+	remotePath := m.config.Artifact.Path(m.config.Deployment.CommitSha)
+
+	ch := make(chan ExecutionResult)
+	for _, w := range m.workers {
+		go func(w *Worker) {
+			start := time.Now()
+			err := w.uploadArtifact(file, remotePath, checksum)
+			ch <- ExecutionResult{origin: w.host.Name, err: err, timeTaken: time.Since(start)}
+		}(w)
+	}
+
+	uploadFailed := false
+	for i := 0; i < len(m.workers); i++ {
+		result := <-ch
+
+		var msg string
+		if result.err != nil {
+			uploadFailed = true
+			msg = fmt.Sprintf("%s - upload of artifact failed: %s (%s)", result.origin, result.err, result.timeTaken)
+		} else {
+			msg = fmt.Sprintf("%s - artifact uploaded to %s (%s)", result.origin, remotePath, result.timeTaken)
+		}
+		m.logger.LogStageResult(msg)
+	}
+
+	if uploadFailed {
+		return fmt.Errorf("Upload of artifact failed")
+	}
+	return nil
+}
+
+// fetchArtifact executes the build script of the artifact on the Applikatoni
+// host or downloads the artifact from its URL, and saves it to file.
+func (m *Manager) fetchArtifact(file string) error {
+	options := m.config.ScriptOptions()
+	options["ArtifactFile"] = file
+
+	artifact := m.config.Artifact
+	if artifact.BuildScript != "" {
+		script, err := artifact.RenderBuildScript(options)
+		if err != nil {
+			return err
+		}
+
+		w := &Worker{
+			host:        &models.Host{Name: models.LOCAL_HOST},
+			logger:      m.logger,
+			killed:      m.killed,
+			gracePeriod: m.config.KillGracePeriod,
+		}
+		return w.executeScript(script, m.deadline, nil)
+	}
+
+	url, err := artifact.RenderURL(options)
+	if err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("download artifact %s", url)
+	m.logger.LogCmdStart("applikatoni", description)
+
+	err = downloadFile(url, file, m.killed, m.deadline)
+	if err != nil {
+		m.logger.LogCmdFail("applikatoni", description, err)
+		return err
+	}
+
+	m.logger.LogCmdSuccess("applikatoni", description)
+	return nil
+}
+
+// uploadArtifact uploads the artifact to remotePath on the host and verifies
+// its checksum there. On the local host the artifact is copied.
+func (w *Worker) uploadArtifact(file, remotePath, checksum string) error {
+	if w.host.IsLocal() {
+		return copyArtifact(file, remotePath, checksum)
+	}
+
+	description := fmt.Sprintf("upload artifact to %s", remotePath)
+	w.logger.LogCmdStart(w.host.Name, description)
+
+	err := scpUpload(w.sshClient, file, remotePath, w.killed, w.deploymentDeadline)
+	if err != nil {
+		w.logger.LogCmdFail(w.host.Name, description, err)
+		return err
+	}
+	w.logger.LogCmdSuccess(w.host.Name, description)
+
+	verify := fmt.Sprintf("echo %s | sha256sum -c --quiet -", shellQuote(checksum+"  "+remotePath))
+	return w.executeScript(verify, w.deploymentDeadline, nil)
+}
+
+func copyArtifact(file, dest, checksum string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	copied, err := fileChecksum(dest)
+	if err != nil {
+		return err
+	}
+	if copied != checksum {
+		return fmt.Errorf("checksum mismatch of %s: want=%s, got=%s", dest, checksum, copied)
+	}
+
+	return nil
+}
+
+// Downloads of artifacts are aborted after this duration, even if the
+// deployment has no timeout
+const artifactDownloadTimeout = 30 * time.Minute
+
+// downloadFile downloads the URL to file. The download is aborted if the
+// deadline is exceeded or the deployment is killed.
+func downloadFile(url, file string, killed <-chan struct{}, d deadline) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d.isZero() {
+		ctx, cancel = context.WithCancel(context.Background())
+	} else {
+		ctx, cancel = context.WithDeadline(context.Background(), d.at)
+	}
+	defer cancel()
+
+	go func() {
+		select {
+		case <-killed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: artifactDownloadTimeout}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return downloadErr(err, killed, d)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("download responded with %d instead of 200", res.StatusCode)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, res.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return downloadErr(err, killed, d)
+	}
+	return nil
+}
+
+// downloadErr returns ErrKilled or the error of the deadline, if the download
+// failed because of them, and err otherwise.
+func downloadErr(err error, killed <-chan struct{}, d deadline) error {
+	select {
+	case <-killed:
+		return ErrKilled
+	default:
+	}
+	if !d.isZero() && !time.Now().Before(d.at) {
+		return d.err()
+	}
+	return err
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of the file
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
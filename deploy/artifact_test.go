@@ -0,0 +1,249 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestScpUpload(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	file := writeTempFile(t, []byte("artifact content"))
+	defer os.Remove(file)
+
+	err := scpUpload(w.sshClient, file, "/tmp/applikatoni-f00.tar.gz", nil, deadline{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case upload := <-s.uploads:
+		if upload.path != "/tmp/applikatoni-f00.tar.gz" {
+			t.Errorf("wrong path. want=%q, got=%q", "/tmp/applikatoni-f00.tar.gz", upload.path)
+		}
+		if upload.content != "artifact content" {
+			t.Errorf("wrong content. want=%q, got=%q", "artifact content", upload.content)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no file uploaded")
+	}
+}
+
+func TestScpUploadKilled(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	file := writeTempFile(t, []byte("artifact content"))
+	defer os.Remove(file)
+
+	killed := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(killed) })
+
+	err := scpUpload(w.sshClient, file, "/hang/applikatoni-f00.tar.gz", killed, deadline{})
+	if err != ErrKilled {
+		t.Errorf("expected ErrKilled, got=%v", err)
+	}
+}
+
+func TestScpUploadDeadline(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	file := writeTempFile(t, []byte("artifact content"))
+	defer os.Remove(file)
+
+	d := deadline{at: time.Now().Add(50 * time.Millisecond), reason: "deployment timeout of 50ms exceeded"}
+
+	err := scpUpload(w.sshClient, file, "/hang/applikatoni-f00.tar.gz", nil, d)
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Errorf("expected a TimeoutError, got=%v", err)
+	}
+}
+
+func TestDistributeArtifact(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "applikatoni-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := NewDeploymentLogger(&models.Deployment{}, nil)
+	go func() {
+		for range logger.ch {
+		}
+	}()
+
+	remote := newTestWorker(t, s)
+	defer remote.Close()
+	remote.logger = logger
+	local := &Worker{host: &models.Host{Name: models.LOCAL_HOST}, logger: logger}
+
+	testManager := &Manager{
+		config: &models.DeploymentConfig{
+			Deployment: &models.Deployment{CommitSha: "f00"},
+			Artifact: &models.Artifact{
+				BuildScript: "printf 'built {{.CommitSha}}' > {{.ArtifactFile}}",
+				RemoteDir:   dir,
+			},
+		},
+		logger:  logger,
+		workers: []*Worker{remote, local},
+	}
+
+	err = testManager.distributeArtifact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPath := filepath.Join(dir, "applikatoni-f00.tar.gz")
+
+	select {
+	case upload := <-s.uploads:
+		if upload.path != expectedPath || upload.content != "built f00" {
+			t.Errorf("wrong upload. got=%+v", upload)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no file uploaded")
+	}
+
+	// The first command is the scp upload
+	<-s.commands
+
+	select {
+	case cmd := <-s.commands:
+		if !strings.Contains(cmd, "sha256sum -c") || !strings.Contains(cmd, expectedPath) {
+			t.Errorf("checksum not verified on the host. got=%q", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("checksum not verified on the host")
+	}
+
+	content, err := ioutil.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "built f00" {
+		t.Errorf("wrong content of local artifact. want=%q, got=%q", "built f00", content)
+	}
+}
+
+func TestFetchArtifactDownload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/artifacts/f00.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "downloaded")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{ts.URL + "/artifacts/{{.CommitSha}}.tar.gz", "downloaded"},
+		{ts.URL + "/artifacts/missing.tar.gz", ""},
+	}
+
+	for i, tt := range tests {
+		testManager := &Manager{
+			config: &models.DeploymentConfig{
+				Deployment: &models.Deployment{CommitSha: "f00"},
+				Artifact:   &models.Artifact{URL: tt.url},
+			},
+			logger: NewDeploymentLogger(&models.Deployment{}, nil),
+		}
+
+		file := writeTempFile(t, nil)
+		defer os.Remove(file)
+
+		err := testManager.fetchArtifact(file)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tt.expected {
+			t.Errorf("%d: wrong content. want=%q, got=%q", i, tt.expected, content)
+		}
+	}
+}
+
+func TestDownloadFileKilled(t *testing.T) {
+	stop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stop)
+
+	file := writeTempFile(t, nil)
+	defer os.Remove(file)
+
+	killed := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(killed) })
+
+	err := downloadFile(ts.URL, file, killed, deadline{})
+	if err != ErrKilled {
+		t.Errorf("expected ErrKilled, got=%v", err)
+	}
+}
+
+func TestDownloadFileDeadline(t *testing.T) {
+	stop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stop)
+
+	file := writeTempFile(t, nil)
+	defer os.Remove(file)
+
+	d := deadline{at: time.Now().Add(50 * time.Millisecond), reason: "deployment timeout of 50ms exceeded"}
+
+	err := downloadFile(ts.URL, file, nil, d)
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Errorf("expected a TimeoutError, got=%v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var ErrNoSshKey = errors.New("no deployment_ssh_key or deployment_ssh_key_file configured")
+var ErrNoSshAgent = errors.New("forward_ssh_agent is set, but SSH_AUTH_SOCK is empty")
+
+// newSigner loads the private key of the deployment user, decrypts it if a
+// passphrase is configured and combines it with the certificate, if any.
+func newSigner(key []byte, auth models.SshAuth) (ssh.Signer, error) {
+	if len(key) == 0 && auth.KeyFile != "" {
+		var err error
+		key, err = ioutil.ReadFile(auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(key) == 0 {
+		return nil, ErrNoSshKey
+	}
+
+	passphrase, err := readPassphrase(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer ssh.Signer
+	if passphrase != nil {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := readCertificate(auth)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		return ssh.NewCertSigner(cert, signer)
+	}
+
+	return signer, nil
+}
+
+func readPassphrase(auth models.SshAuth) ([]byte, error) {
+	if auth.PassphraseEnv != "" {
+		passphrase := os.Getenv(auth.PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("environment variable %s with the passphrase is empty", auth.PassphraseEnv)
+		}
+		return []byte(passphrase), nil
+	}
+
+	if auth.PassphraseFile != "" {
+		passphrase, err := ioutil.ReadFile(auth.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(passphrase), "\r\n")), nil
+	}
+
+	return nil, nil
+}
+
+func readCertificate(auth models.SshAuth) (*ssh.Certificate, error) {
+	certificate := []byte(auth.Certificate)
+	if len(certificate) == 0 && auth.CertificateFile != "" {
+		var err error
+		certificate, err = ioutil.ReadFile(auth.CertificateFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(certificate) == 0 {
+		return nil, nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ssh certificate failed: %s", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("deployment_ssh_certificate is a %s key, not a certificate", pub.Type())
+	}
+
+	return cert, nil
+}
+
+// newLocalAgent connects to the ssh-agent running on the Applikatoni server
+func newLocalAgent() (agent.Agent, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, ErrNoSshAgent
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
@@ -0,0 +1,134 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateEncryptedPrivateKey(t *testing.T, passphrase string) []byte {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+func generateCertificate(t *testing.T, key []byte) []byte {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := ssh.ParsePrivateKey(generatePrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "deploy",
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	err = cert.SignCert(rand.Reader, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+func writeTempFile(t *testing.T, content []byte) string {
+	f, err := ioutil.TempFile("", "applikatoni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestNewSigner(t *testing.T) {
+	key := generatePrivateKey(t)
+	encryptedKey := generateEncryptedPrivateKey(t, "s3cr3t")
+	certificate := generateCertificate(t, key)
+
+	keyFile := writeTempFile(t, key)
+	defer os.Remove(keyFile)
+	passphraseFile := writeTempFile(t, []byte("s3cr3t\n"))
+	defer os.Remove(passphraseFile)
+	certificateFile := writeTempFile(t, certificate)
+	defer os.Remove(certificateFile)
+
+	os.Setenv("APPLIKATONI_TEST_PASSPHRASE", "s3cr3t")
+	defer os.Unsetenv("APPLIKATONI_TEST_PASSPHRASE")
+
+	tests := []struct {
+		key      []byte
+		auth     models.SshAuth
+		wantCert bool
+		wantErr  bool
+	}{
+		{key, models.SshAuth{}, false, false},
+		{nil, models.SshAuth{KeyFile: keyFile}, false, false},
+		{encryptedKey, models.SshAuth{PassphraseEnv: "APPLIKATONI_TEST_PASSPHRASE"}, false, false},
+		{encryptedKey, models.SshAuth{PassphraseFile: passphraseFile}, false, false},
+		{key, models.SshAuth{Certificate: string(certificate)}, true, false},
+		{nil, models.SshAuth{KeyFile: keyFile, CertificateFile: certificateFile}, true, false},
+		{nil, models.SshAuth{}, false, true},
+		{nil, models.SshAuth{KeyFile: "/does/not/exist"}, false, true},
+		{encryptedKey, models.SshAuth{}, false, true},
+		{encryptedKey, models.SshAuth{PassphraseEnv: "APPLIKATONI_TEST_NOT_SET"}, false, true},
+		{key, models.SshAuth{Certificate: "not a certificate"}, false, true},
+		{key, models.SshAuth{Certificate: knownHostsLine("", generateHostKey(t))}, false, true},
+	}
+
+	for i, tt := range tests {
+		signer, err := newSigner(tt.key, tt.auth)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: expected no error, got=%s", i, err)
+			continue
+		}
+
+		_, isCert := signer.PublicKey().(*ssh.Certificate)
+		if isCert != tt.wantCert {
+			t.Errorf("%d: wrong signer. want certificate=%t, got certificate=%t", i, tt.wantCert, isCert)
+		}
+	}
+}
+
+func TestNewLocalAgentWithoutSocket(t *testing.T) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", socket)
+
+	_, _, err := newLocalAgent()
+	if err != ErrNoSshAgent {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNoSshAgent, err)
+	}
+}
@@ -46,9 +46,20 @@ func ConsoleLogger(logs <-chan LogEntry) {
 			log.Printf("%sDEPLOYMENT FAILED: %s%s", ASCII_RED, entry.Message, ASCII_RESET)
 		case DEPLOYMENT_SUCCESS:
 			log.Printf("%sDEPLOYMENT FINISHED: %s%s", ASCII_MAGENTA, entry.Message, ASCII_RESET)
+		case DEPLOYMENT_PARTIAL:
+			log.Printf("%sDEPLOYMENT PARTIALLY FINISHED: %s%s", ASCII_YELLOW, entry.Message, ASCII_RESET)
 
 		case KILL_RECEIVED:
 			log.Printf("%sKILL RECEIVED: %s%s", ASCII_RED, entry.Message, ASCII_RESET)
+		case DEPLOYMENT_KILLED:
+			log.Printf("%sDEPLOYMENT KILLED: %s%s", ASCII_RED, entry.Message, ASCII_RESET)
+
+		case ROLLBACK_START:
+			log.Printf("%sSTARTING ROLLBACK: %s%s", ASCII_YELLOW, entry.Message, ASCII_RESET)
+		case ROLLBACK_FAIL:
+			log.Printf("%sROLLBACK FAILED: %s%s", ASCII_RED, entry.Message, ASCII_RESET)
+		case ROLLBACK_SUCCESS:
+			log.Printf("%sROLLBACK FINISHED: %s%s", ASCII_MAGENTA, entry.Message, ASCII_RESET)
 		}
 	}
 }
@@ -2,6 +2,8 @@ package deploy
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +24,10 @@ type DeploymentLogger struct {
 	// the router. Only returns on Wait() if all logs have been sent to the
 	// router. Used in Flush().
 	wg sync.WaitGroup
+
+	// Masks secrets in the messages of every LogEntry before it's sent to
+	// the router
+	redactor redactor
 }
 
 func NewDeploymentLogger(d *models.Deployment, r *LogRouter) *DeploymentLogger {
@@ -46,10 +52,24 @@ func (l *DeploymentLogger) BroadcastLogs() {
 }
 
 func (l *DeploymentLogger) Log(entry LogEntry) {
+	entry.Message = l.redactor.redact(entry.Message)
+
 	l.wg.Add(1)
 	l.ch <- entry
 }
 
+// MaskValues makes the logger replace the values in all following log
+// entries with models.MaskedValue.
+func (l *DeploymentLogger) MaskValues(values ...string) {
+	l.redactor.addValues(values...)
+}
+
+// MaskPatterns makes the logger replace the matches of the patterns in all
+// following log entries with models.MaskedValue.
+func (l *DeploymentLogger) MaskPatterns(patterns ...*regexp.Regexp) {
+	l.redactor.addPatterns(patterns...)
+}
+
 func (l *DeploymentLogger) Flush() {
 	l.wg.Wait() // Wait for `ch` to drain
 	close(l.ch)
@@ -144,6 +164,19 @@ func (l *DeploymentLogger) LogDeploymentStart() {
 	l.Log(entry)
 }
 
+// LogDeploymentPartialSuccess logs the end of a deployment that finished
+// although the hosts failed a stage.
+func (l *DeploymentLogger) LogDeploymentPartialSuccess(failedHosts []string) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: DEPLOYMENT_PARTIAL,
+		Message:   fmt.Sprintf("deployment_id=%d failed_hosts=%s", l.deployment.Id, strings.Join(failedHosts, ",")),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
 func (l *DeploymentLogger) LogDeploymentSuccess() {
 	entry := LogEntry{
 		Origin:    "applikatoni",
@@ -166,11 +199,55 @@ func (l *DeploymentLogger) LogDeploymentFail(err error) {
 	l.Log(entry)
 }
 
-func (l *DeploymentLogger) LogKillReceived() {
+func (l *DeploymentLogger) LogKillReceived(killer string) {
 	entry := LogEntry{
 		Origin:    "applikatoni",
 		EntryType: KILL_RECEIVED,
-		Message:   "deployment will be stopped after current stage",
+		Message:   fmt.Sprintf("killed by %s, running commands will be terminated", killer),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogDeploymentKilled(killer string) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: DEPLOYMENT_KILLED,
+		Message:   fmt.Sprintf("killed by %s", killer),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogRollbackStart(commitSha string) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: ROLLBACK_START,
+		Message:   fmt.Sprintf("rolling back to commit_sha=%s", commitSha),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogRollbackSuccess() {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: ROLLBACK_SUCCESS,
+		Message:   fmt.Sprintf("deployment_id=%d", l.deployment.Id),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogRollbackFail(err error) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: ROLLBACK_FAIL,
+		Message:   fmt.Sprintf("deployment_id=%d, err=%s", l.deployment.Id, err),
 		Timestamp: time.Now(),
 	}
 
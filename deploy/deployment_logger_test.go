@@ -1,6 +1,7 @@
 package deploy
 
 import (
+	"regexp"
 	"testing"
 	"time"
 
@@ -119,3 +120,35 @@ func TestLogCmdStart(t *testing.T) {
 		t.Errorf("wrong message. expected=%s, got=%s", "whoami", entry.Message)
 	}
 }
+
+func TestMaskValues(t *testing.T) {
+	logger := NewDeploymentLogger(deployment, nil)
+	logger.MaskValues("s3cr3t", "")
+
+	logger.LogCmdStart("example.org", "mysql -p s3cr3t")
+	logger.Log(LogEntry{EntryType: COMMAND_STDOUT_OUTPUT, Message: "password is s3cr3t, really s3cr3t\n"})
+
+	expected := []string{"mysql -p ****", "password is ****, really ****\n"}
+	for _, msg := range expected {
+		entry := <-logger.ch
+		if entry.Message != msg {
+			t.Errorf("wrong message. want=%q, got=%q", msg, entry.Message)
+		}
+	}
+}
+
+func TestMaskPatterns(t *testing.T) {
+	logger := NewDeploymentLogger(deployment, nil)
+	logger.MaskPatterns(regexp.MustCompile(`--password (\S+)`))
+
+	logger.LogCmdStart("example.org", "mysql --password hunter2 -e 'SELECT 1'")
+	logger.Log(LogEntry{EntryType: COMMAND_STDERR_OUTPUT, Message: "failed: --password hunter2\n"})
+
+	expected := []string{"mysql --password **** -e 'SELECT 1'", "failed: --password ****\n"}
+	for _, msg := range expected {
+		entry := <-logger.ch
+		if entry.Message != msg {
+			t.Errorf("wrong message. want=%q, got=%q", msg, entry.Message)
+		}
+	}
+}
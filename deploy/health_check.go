@@ -0,0 +1,179 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// Only this much of a response body is searched for the expected body
+const maxHealthCheckBodySize = 1 << 20
+
+// healthChecker runs the health checks of the VERIFY stage for one origin,
+// which is either a host or Applikatoni itself.
+type healthChecker struct {
+	origin string
+	// Health checks with via_ssh are requested through this connection. Nil
+	// for the health checks of the target.
+	sshClient *ssh.Client
+	logger    *DeploymentLogger
+	killed    <-chan struct{}
+}
+
+// run executes the health checks one after another and stops at the first
+// one that doesn't pass. masked holds the same health checks rendered with
+// the secret options masked, which are used for logging.
+func (c *healthChecker) run(checks, masked []*models.HealthCheck, d deadline) error {
+	for i, hc := range checks {
+		err := c.check(hc, masked[i].URL, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *healthChecker) check(hc *models.HealthCheck, displayURL string, d deadline) error {
+	description := fmt.Sprintf("health check GET %s", displayURL)
+	c.logger.LogCmdStart(c.origin, description)
+
+	attempts := hc.Retries + 1
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			err = c.pause(hc.Interval(), d)
+			if err != nil {
+				break
+			}
+		}
+
+		err = c.request(hc, d)
+		if err == nil {
+			c.logger.LogCmdSuccess(c.origin, description)
+			return nil
+		}
+		if err == ErrKilled || isTimeout(err) {
+			break
+		}
+
+		c.logger.Log(LogEntry{
+			Origin:    c.origin,
+			EntryType: COMMAND_STDERR_OUTPUT,
+			Message:   fmt.Sprintf("attempt %d/%d failed: %s\n", attempt, attempts, err),
+			Timestamp: time.Now(),
+		})
+	}
+
+	c.logger.LogCmdFail(c.origin, description, err)
+	return err
+}
+
+// pause waits for the interval between two attempts, unless the deadline is
+// exceeded or the deployment is killed first.
+func (c *healthChecker) pause(interval time.Duration, d deadline) error {
+	var timeout <-chan time.Time
+	if !d.isZero() {
+		timer := time.NewTimer(d.at.Sub(time.Now()))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-time.After(interval):
+		return nil
+	case <-timeout:
+		return d.err()
+	case <-c.killed:
+		return ErrKilled
+	}
+}
+
+func (c *healthChecker) request(hc *models.HealthCheck, d deadline) error {
+	timeout := hc.Timeout()
+	if !d.isZero() {
+		remaining := d.at.Sub(time.Now())
+		if remaining <= 0 {
+			return d.err()
+		}
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c.killed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequest("GET", hc.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: c.transport(hc)}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if c.wasKilled() {
+			return ErrKilled
+		}
+		if !d.isZero() && !time.Now().Before(d.at) {
+			return d.err()
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != hc.Status() {
+		return fmt.Errorf("expected status %d, got %d", hc.Status(), res.StatusCode)
+	}
+
+	if hc.ExpectedBody == "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxHealthCheckBodySize))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(body), hc.ExpectedBody) {
+		return fmt.Errorf("response body does not contain %q", hc.ExpectedBody)
+	}
+
+	return nil
+}
+
+func (c *healthChecker) transport(hc *models.HealthCheck) http.RoundTripper {
+	if hc.ViaSSH && c.sshClient != nil {
+		return &http.Transport{Dial: c.sshClient.Dial, DisableKeepAlives: true}
+	}
+	return http.DefaultTransport
+}
+
+func (c *healthChecker) wasKilled() bool {
+	select {
+	case <-c.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
@@ -0,0 +1,184 @@
+package deploy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func newTestHealthChecker() *healthChecker {
+	return &healthChecker{
+		origin: "applikatoni",
+		logger: NewDeploymentLogger(&models.Deployment{}, nil),
+	}
+}
+
+func TestHealthCheckerCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprint(w, "f00b4r")
+		case "/broken":
+			http.Error(w, "broken", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		check *models.HealthCheck
+		pass  bool
+	}{
+		{&models.HealthCheck{URL: ts.URL + "/version"}, true},
+		{&models.HealthCheck{URL: ts.URL + "/version", ExpectedBody: "f00b4r"}, true},
+		{&models.HealthCheck{URL: ts.URL + "/version", ExpectedBody: "0ld"}, false},
+		{&models.HealthCheck{URL: ts.URL + "/broken"}, false},
+		{&models.HealthCheck{URL: ts.URL + "/broken", ExpectedStatus: 503}, true},
+		{&models.HealthCheck{URL: ts.URL + "/missing", ExpectedStatus: 404}, true},
+	}
+
+	for i, tt := range tests {
+		c := newTestHealthChecker()
+		err := c.check(tt.check, tt.check.URL, deadline{})
+		if (err == nil) != tt.pass {
+			t.Errorf("%d: wrong result. want pass=%t, got=%v", i, tt.pass, err)
+		}
+	}
+}
+
+func TestHealthCheckerRetries(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	check := &models.HealthCheck{URL: ts.URL, Retries: 2, IntervalSeconds: 1}
+	err := newTestHealthChecker().check(check, check.URL, deadline{})
+	if err != nil {
+		t.Errorf("expected health check to pass after retries, got=%s", err)
+	}
+	if requests != 3 {
+		t.Errorf("wrong number of requests. want=%d, got=%d", 3, requests)
+	}
+}
+
+func TestHealthCheckerDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	d := deadline{at: time.Now().Add(100 * time.Millisecond), reason: "stage timeout"}
+	check := &models.HealthCheck{URL: ts.URL, Retries: 10, IntervalSeconds: 1}
+
+	start := time.Now()
+	err := newTestHealthChecker().check(check, check.URL, d)
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Errorf("expected a TimeoutError, got=%v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("health check did not stop at the deadline")
+	}
+}
+
+func TestWorkerExecuteVerify(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	checks := []*models.HealthCheck{{URL: ts.URL}}
+	w := &Worker{
+		host:               testHosts[0],
+		scripts:            map[models.DeploymentStage]string{},
+		healthChecks:       checks,
+		maskedHealthChecks: checks,
+		logger:             NewDeploymentLogger(&models.Deployment{}, nil),
+	}
+
+	if !w.HasScript(models.STAGE_VERIFY) {
+		t.Fatalf("expected worker with health checks to have VERIFY stage")
+	}
+	if w.HasScript(preDeployment) {
+		t.Errorf("expected worker to have no %s script", preDeployment)
+	}
+
+	result := w.Execute(models.STAGE_VERIFY)
+	if result.err != nil || result.skipped {
+		t.Errorf("expected VERIFY to pass, got=%+v", result)
+	}
+	if w.touched {
+		t.Errorf("expected health checks not to touch the host")
+	}
+}
+
+func TestNewWorkerHealthChecks(t *testing.T) {
+	testManager := &Manager{logger: &DeploymentLogger{}}
+	testManager.config = &models.DeploymentConfig{
+		Roles: []*models.Role{
+			&models.Role{
+				Name:          "web",
+				Options:       map[string]string{"Token": "s3cr3t"},
+				SecretOptions: []string{"Token"},
+				HealthChecks: []*models.HealthCheck{
+					{URL: "http://{{.Host}}/health?token={{.Token}}", ExpectedBody: "{{.CommitSha}}"},
+				},
+			},
+		},
+	}
+
+	host := &models.Host{Name: "web1.applikatoni.com:22", Roles: []string{"web"}}
+	options := map[string]string{"CommitSha": "f00b4r"}
+
+	w, err := testManager.newWorker(host, options, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.healthChecks) != 1 || len(w.maskedHealthChecks) != 1 {
+		t.Fatalf("wrong number of health checks. want=1, got=%d", len(w.healthChecks))
+	}
+
+	expectedURL := "http://web1.applikatoni.com/health?token=s3cr3t"
+	if w.healthChecks[0].URL != expectedURL {
+		t.Errorf("wrong url. want=%q, got=%q", expectedURL, w.healthChecks[0].URL)
+	}
+	if w.healthChecks[0].ExpectedBody != "f00b4r" {
+		t.Errorf("wrong expected body. want=%q, got=%q", "f00b4r", w.healthChecks[0].ExpectedBody)
+	}
+
+	expectedMaskedURL := "http://web1.applikatoni.com/health?token=" + models.MaskedValue
+	if w.maskedHealthChecks[0].URL != expectedMaskedURL {
+		t.Errorf("wrong masked url. want=%q, got=%q", expectedMaskedURL, w.maskedHealthChecks[0].URL)
+	}
+}
+
+func TestManagerMaskHealthCheck(t *testing.T) {
+	testManager := &Manager{logger: NewDeploymentLogger(&models.Deployment{}, nil)}
+	testManager.logger.MaskValues("s3cr3t")
+
+	hc := &models.HealthCheck{URL: "http://lb.applikatoni.com/health?token=s3cr3t", ExpectedBody: "ok"}
+	masked := testManager.maskHealthCheck(hc)
+
+	expectedMaskedURL := "http://lb.applikatoni.com/health?token=" + models.MaskedValue
+	if masked.URL != expectedMaskedURL {
+		t.Errorf("wrong masked url. want=%q, got=%q", expectedMaskedURL, masked.URL)
+	}
+	if hc.URL != "http://lb.applikatoni.com/health?token=s3cr3t" {
+		t.Errorf("rendered health check was changed: %q", hc.URL)
+	}
+	if masked.ExpectedBody != "ok" {
+		t.Errorf("wrong expected body. want=%q, got=%q", "ok", masked.ExpectedBody)
+	}
+}
@@ -0,0 +1,301 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyStore pins the host keys of hosts that are not configured in the
+// target, when the target uses the accept-new host key mode.
+type HostKeyStore interface {
+	// GetHostKey returns the pinned key of host in the authorized_keys
+	// format, or an empty string if no key is pinned yet.
+	GetHostKey(host string) (string, error)
+	// PinHostKey saves the key (in the authorized_keys format) for host.
+	PinHostKey(host, key string) error
+}
+
+var ErrNoHostKeyStore = errors.New("host key mode accept-new needs a host key store")
+
+type knownHostKey struct {
+	patterns []string
+	key      ssh.PublicKey
+	revoked  bool
+}
+
+type hostKeyVerifier struct {
+	mode       models.HostKeyMode
+	inlineKeys []knownHostKey
+	fileCheck  ssh.HostKeyCallback
+	store      HostKeyStore
+
+	// Workers may connect to the same host at the same time
+	mu sync.Mutex
+}
+
+func newHostKeyCallback(c *models.DeploymentConfig, store HostKeyStore) (ssh.HostKeyCallback, error) {
+	mode := c.HostKeyMode
+	if mode == "" {
+		mode = models.HOST_KEY_ACCEPT_NEW
+	}
+	if mode != models.HOST_KEY_STRICT && mode != models.HOST_KEY_ACCEPT_NEW {
+		return nil, fmt.Errorf("unknown host key mode %q", mode)
+	}
+	if mode == models.HOST_KEY_ACCEPT_NEW && store == nil {
+		return nil, ErrNoHostKeyStore
+	}
+
+	inlineKeys, err := parseKnownHostKeys(c.HostKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &hostKeyVerifier{mode: mode, inlineKeys: inlineKeys, store: store}
+
+	if c.KnownHostsFile != "" {
+		v.fileCheck, err = knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v.check, nil
+}
+
+func parseKnownHostKeys(lines []string) ([]knownHostKey, error) {
+	keys := []knownHostKey{}
+
+	rest := []byte(strings.Join(lines, "\n"))
+	for {
+		marker, hosts, key, _, r, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing host_keys failed: %s", err)
+		}
+
+		for _, p := range hosts {
+			if strings.HasPrefix(strings.TrimPrefix(p, "!"), "|") {
+				if _, _, err := decodeHashedHost(strings.TrimPrefix(p, "!")); err != nil {
+					return nil, fmt.Errorf("parsing host_keys failed: %s", err)
+				}
+			}
+		}
+
+		keys = append(keys, knownHostKey{
+			patterns: hosts,
+			key:      key,
+			revoked:  marker == "revoked",
+		})
+		rest = r
+	}
+
+	return keys, nil
+}
+
+func (v *hostKeyVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	known, err := v.checkInline(hostname, key)
+	if known || err != nil {
+		return err
+	}
+
+	known, err = v.checkFile(hostname, remote, key)
+	if known || err != nil {
+		return err
+	}
+
+	if v.mode == models.HOST_KEY_STRICT {
+		return fmt.Errorf("host key verification failed for %s: host is unknown (%s key %s)",
+			hostname, key.Type(), ssh.FingerprintSHA256(key))
+	}
+
+	return v.checkPinned(hostname, key)
+}
+
+// checkInline reports whether hostname is configured in the target's
+// host_keys and returns an error if the key doesn't match.
+func (v *hostKeyVerifier) checkInline(hostname string, key ssh.PublicKey) (bool, error) {
+	normalized := knownhosts.Normalize(hostname)
+	known := false
+
+	for _, k := range v.inlineKeys {
+		if !matchesHost(k.patterns, normalized) {
+			continue
+		}
+
+		if k.revoked {
+			if keysEqual(k.key, key) {
+				return true, fmt.Errorf("host key verification failed for %s: %s key %s is revoked",
+					hostname, key.Type(), ssh.FingerprintSHA256(key))
+			}
+			continue
+		}
+
+		known = true
+		if keysEqual(k.key, key) {
+			return true, nil
+		}
+	}
+
+	if known {
+		return true, mismatchError(hostname, key)
+	}
+
+	return false, nil
+}
+
+// checkFile reports whether hostname is configured in the target's
+// known_hosts_file and returns an error if the key doesn't match.
+func (v *hostKeyVerifier) checkFile(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	if v.fileCheck == nil {
+		return false, nil
+	}
+
+	err := v.fileCheck(hostname, remote, key)
+	if err == nil {
+		return true, nil
+	}
+
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if ok && len(keyErr.Want) == 0 {
+		return false, nil
+	}
+	if ok {
+		return true, mismatchError(hostname, key)
+	}
+
+	return true, fmt.Errorf("host key verification failed for %s: %s", hostname, err)
+}
+
+func (v *hostKeyVerifier) checkPinned(hostname string, key ssh.PublicKey) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	normalized := knownhosts.Normalize(hostname)
+
+	pinned, err := v.store.GetHostKey(normalized)
+	if err != nil {
+		return err
+	}
+
+	if pinned == "" {
+		marshaled := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		return v.store.PinHostKey(normalized, marshaled)
+	}
+
+	pinnedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+	if err != nil {
+		return fmt.Errorf("parsing pinned host key of %s failed: %s", hostname, err)
+	}
+
+	if !keysEqual(pinnedKey, key) {
+		return mismatchError(hostname, key)
+	}
+
+	return nil
+}
+
+// matchesHost reports whether the host patterns of a known_hosts line match
+// the normalized hostname, with the rules of OpenSSH: a pattern is either
+// hashed (|1|salt|hash, see ssh-keyscan -H) or may contain the wildcards *
+// and ?, and a pattern prefixed with ! excludes the hosts it matches, even if
+// another pattern of the line matches them.
+func matchesHost(patterns []string, normalized string) bool {
+	matched := false
+
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		if !matchesPattern(strings.TrimPrefix(p, "!"), normalized) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+func matchesPattern(pattern, normalized string) bool {
+	if strings.HasPrefix(pattern, "|") {
+		salt, hash, err := decodeHashedHost(pattern)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(normalized))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	return matchesWildcard(knownhosts.Normalize(pattern), normalized)
+}
+
+// decodeHashedHost returns the salt and the hash of a hashed host pattern.
+func decodeHashedHost(pattern string) ([]byte, []byte, error) {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return nil, nil, fmt.Errorf("unsupported hashed host %q", pattern)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid salt of hashed host %q", pattern)
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid hash of hashed host %q", pattern)
+	}
+
+	return salt, hash, nil
+}
+
+// matchesWildcard matches s against a pattern in which * stands for any
+// number of characters and ? for a single one.
+func matchesWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchesWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+func keysEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+func mismatchError(hostname string, key ssh.PublicKey) error {
+	return fmt.Errorf("host key verification failed for %s: host key mismatch, got %s key %s",
+		hostname, key.Type(), ssh.FingerprintSHA256(key))
+}
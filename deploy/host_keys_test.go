@@ -0,0 +1,233 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var testRemoteAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+type memoryHostKeyStore map[string]string
+
+func (s memoryHostKeyStore) GetHostKey(host string) (string, error) {
+	return s[host], nil
+}
+
+func (s memoryHostKeyStore) PinHostKey(host, key string) error {
+	s[host] = key
+	return nil
+}
+
+func generateHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func knownHostsLine(host string, key ssh.PublicKey) string {
+	return host + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestHostKeyCallbackInlineKeys(t *testing.T) {
+	key := generateHostKey(t)
+	otherKey := generateHostKey(t)
+
+	tests := []struct {
+		host    string
+		key     ssh.PublicKey
+		mode    models.HostKeyMode
+		wantErr bool
+	}{
+		{"web.applikatoni.com:22", key, models.HOST_KEY_STRICT, false},
+		{"web.applikatoni.com:22", otherKey, models.HOST_KEY_STRICT, true},
+		{"web.applikatoni.com:22", otherKey, models.HOST_KEY_ACCEPT_NEW, true},
+		{"web.applikatoni.com:2222", key, models.HOST_KEY_STRICT, true},
+		{"workers.applikatoni.com:22", key, models.HOST_KEY_STRICT, true},
+		{"workers.applikatoni.com:22", key, models.HOST_KEY_ACCEPT_NEW, false},
+	}
+
+	for _, tt := range tests {
+		config := &models.DeploymentConfig{
+			HostKeys:    []string{knownHostsLine("web.applikatoni.com", key)},
+			HostKeyMode: tt.mode,
+		}
+
+		callback, err := newHostKeyCallback(config, memoryHostKeyStore{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = callback(tt.host, testRemoteAddr, tt.key)
+		if tt.wantErr && err == nil {
+			t.Errorf("expected error for host %s in mode %s, got none", tt.host, tt.mode)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("expected no error for host %s in mode %s, got=%s", tt.host, tt.mode, err)
+		}
+	}
+}
+
+func TestHostKeyCallbackInlineKeyPatterns(t *testing.T) {
+	key := generateHostKey(t)
+	otherKey := generateHostKey(t)
+
+	hostKeys := []string{
+		knownHostsLine(knownhosts.HashHostname("hashed.applikatoni.com"), key),
+		knownHostsLine(knownhosts.HashHostname("[hashed.applikatoni.com]:2222"), key),
+		knownHostsLine("*.web.applikatoni.com,!db.web.applikatoni.com", key),
+		knownHostsLine("[worker?.applikatoni.com]:2222", key),
+	}
+
+	tests := []struct {
+		host    string
+		key     ssh.PublicKey
+		wantErr bool
+	}{
+		{"hashed.applikatoni.com:22", key, false},
+		{"hashed.applikatoni.com:22", otherKey, true},
+		{"hashed.applikatoni.com:2222", key, false},
+		{"hashed.applikatoni.com:2222", otherKey, true},
+		{"app1.web.applikatoni.com:22", key, false},
+		{"app1.web.applikatoni.com:22", otherKey, true},
+		{"app1.web.applikatoni.com:2222", key, true},
+		{"db.web.applikatoni.com:22", key, true},
+		{"worker1.applikatoni.com:2222", key, false},
+		{"worker1.applikatoni.com:2222", otherKey, true},
+		{"worker10.applikatoni.com:2222", key, true},
+	}
+
+	for _, tt := range tests {
+		config := &models.DeploymentConfig{
+			HostKeys:    hostKeys,
+			HostKeyMode: models.HOST_KEY_STRICT,
+		}
+
+		callback, err := newHostKeyCallback(config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = callback(tt.host, testRemoteAddr, tt.key)
+		if tt.wantErr && err == nil {
+			t.Errorf("expected error for host %s, got none", tt.host)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("expected no error for host %s, got=%s", tt.host, err)
+		}
+	}
+}
+
+func TestHostKeyCallbackInvalidHashedHost(t *testing.T) {
+	config := &models.DeploymentConfig{
+		HostKeys:    []string{knownHostsLine("|2|c2FsdA==|aGFzaA==", generateHostKey(t))},
+		HostKeyMode: models.HOST_KEY_STRICT,
+	}
+
+	_, err := newHostKeyCallback(config, nil)
+	if err == nil {
+		t.Errorf("expected an error for an unsupported hashed host")
+	}
+}
+
+func TestHostKeyCallbackKnownHostsFile(t *testing.T) {
+	key := generateHostKey(t)
+
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(knownHostsLine("web.applikatoni.com", key) + "\n")
+	f.Close()
+
+	config := &models.DeploymentConfig{
+		KnownHostsFile: f.Name(),
+		HostKeyMode:    models.HOST_KEY_STRICT,
+	}
+
+	callback, err := newHostKeyCallback(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = callback("web.applikatoni.com:22", testRemoteAddr, key)
+	if err != nil {
+		t.Errorf("expected no error for known key, got=%s", err)
+	}
+
+	err = callback("web.applikatoni.com:22", testRemoteAddr, generateHostKey(t))
+	if err == nil {
+		t.Errorf("expected error for mismatching key, got none")
+	}
+
+	err = callback("workers.applikatoni.com:22", testRemoteAddr, key)
+	if err == nil {
+		t.Errorf("expected error for unknown host, got none")
+	}
+}
+
+func TestHostKeyCallbackPinsNewHosts(t *testing.T) {
+	store := memoryHostKeyStore{}
+	key := generateHostKey(t)
+
+	callback, err := newHostKeyCallback(&models.DeploymentConfig{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = callback("web.applikatoni.com:22", testRemoteAddr, key)
+	if err != nil {
+		t.Fatalf("expected first key to be accepted, got=%s", err)
+	}
+
+	if _, ok := store["web.applikatoni.com"]; !ok {
+		t.Errorf("expected key to be pinned. pinned=%v", store)
+	}
+
+	err = callback("web.applikatoni.com:22", testRemoteAddr, key)
+	if err != nil {
+		t.Errorf("expected pinned key to be accepted, got=%s", err)
+	}
+
+	err = callback("web.applikatoni.com:22", testRemoteAddr, generateHostKey(t))
+	if err == nil {
+		t.Errorf("expected error for key not matching the pinned key, got none")
+	}
+}
+
+func TestNewHostKeyCallbackErrors(t *testing.T) {
+	tests := []struct {
+		config *models.DeploymentConfig
+		store  HostKeyStore
+	}{
+		{&models.DeploymentConfig{HostKeyMode: "yolo"}, memoryHostKeyStore{}},
+		{&models.DeploymentConfig{HostKeyMode: models.HOST_KEY_ACCEPT_NEW}, nil},
+		{&models.DeploymentConfig{HostKeys: []string{"not a known_hosts line"}}, memoryHostKeyStore{}},
+		{&models.DeploymentConfig{KnownHostsFile: "/does/not/exist"}, memoryHostKeyStore{}},
+	}
+
+	for _, tt := range tests {
+		_, err := newHostKeyCallback(tt.config, tt.store)
+		if err == nil {
+			t.Errorf("expected error for config %+v, got none", tt.config)
+		}
+	}
+}
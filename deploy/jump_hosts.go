@@ -0,0 +1,101 @@
+package deploy
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHostPool holds the connections to the jump hosts of a deployment, so
+// that all Workers tunneling through the same jump hosts share them.
+type jumpHostPool struct {
+	user            string
+	signer          ssh.Signer
+	hostKeyCallback ssh.HostKeyCallback
+
+	mu      sync.Mutex
+	clients map[string]*ssh.Client
+	// In the order the connections were opened, so they can be closed
+	// in reverse order
+	opened []*ssh.Client
+}
+
+func newJumpHostPool(user string, signer ssh.Signer, hostKeyCallback ssh.HostKeyCallback) *jumpHostPool {
+	return &jumpHostPool{
+		user:            user,
+		signer:          signer,
+		hostKeyCallback: hostKeyCallback,
+		clients:         make(map[string]*ssh.Client),
+	}
+}
+
+// Dial connects to host through the chain of jump hosts, reusing the
+// connections to jump hosts that are already open.
+func (p *jumpHostPool) Dial(chain []*models.JumpHost, host string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var jumpClient *ssh.Client
+	hops := []string{}
+
+	for _, jumpHost := range chain {
+		config, err := p.clientConfig(jumpHost)
+		if err != nil {
+			return nil, err
+		}
+
+		hops = append(hops, config.User+"@"+jumpHost.Name)
+		key := strings.Join(hops, ">")
+
+		if c, ok := p.clients[key]; ok {
+			jumpClient = c
+			continue
+		}
+
+		if jumpClient == nil {
+			jumpClient, err = newSSHClient(jumpHost.Name, config)
+		} else {
+			jumpClient, err = newSSHClientThrough(jumpClient, jumpHost.Name, config)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.clients[key] = jumpClient
+		p.opened = append(p.opened, jumpClient)
+	}
+
+	return newSSHClientThrough(jumpClient, host, sshConfig)
+}
+
+func (p *jumpHostPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.opened) - 1; i >= 0; i-- {
+		p.opened[i].Close()
+	}
+
+	p.opened = nil
+	p.clients = make(map[string]*ssh.Client)
+}
+
+func (p *jumpHostPool) clientConfig(jumpHost *models.JumpHost) (*ssh.ClientConfig, error) {
+	user := jumpHost.User
+	if user == "" {
+		user = p.user
+	}
+
+	signer := p.signer
+	if jumpHost.SshKey != "" {
+		var err error
+		signer, err = ssh.ParsePrivateKey([]byte(jumpHost.SshKey))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newSSHClientConfig(user, signer, p.hostKeyCallback), nil
+}
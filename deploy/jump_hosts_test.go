@@ -0,0 +1,59 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func generatePrivateKey(t *testing.T) []byte {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+func TestJumpHostPoolClientConfig(t *testing.T) {
+	deploymentSigner, err := ssh.ParsePrivateKey(generatePrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jumpHostKey := generatePrivateKey(t)
+
+	pool := newJumpHostPool("deploy", deploymentSigner, nil)
+
+	tests := []struct {
+		jumpHost     *models.JumpHost
+		expectedUser string
+	}{
+		{&models.JumpHost{Name: "bastion.applikatoni.com:22"}, "deploy"},
+		{&models.JumpHost{Name: "bastion.applikatoni.com:22", User: "jump", SshKey: string(jumpHostKey)}, "jump"},
+	}
+
+	for _, tt := range tests {
+		config, err := pool.clientConfig(tt.jumpHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.User != tt.expectedUser {
+			t.Errorf("wrong user. want=%s, got=%s", tt.expectedUser, config.User)
+		}
+	}
+
+	_, err = pool.clientConfig(&models.JumpHost{Name: "bastion.applikatoni.com:22", SshKey: "invalid"})
+	if err == nil {
+		t.Errorf("expected error for invalid ssh key, got none")
+	}
+}
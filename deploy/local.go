@@ -0,0 +1,120 @@
+package deploy
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// localEnvAllowed are the variables of Applikatoni's own environment that are
+// passed on to the commands of the local host. Everything else, like the key
+// of the secrets, stays out of reach of the scripts.
+var localEnvAllowed = []string{"PATH", "HOME", "LANG"}
+
+// runLocalCommand executes the command on the Applikatoni host with sh,
+// instead of on a remote host over SSH. The output is logged just like the
+// output of remote commands.
+func (w *Worker) runLocalCommand(cmd string, stdin io.Reader, d deadline) error {
+	c := exec.Command("/bin/sh", "-c", cmd)
+	c.Stdin = stdin
+	c.Env = w.localEnv()
+	// Run the command in its own process group, so the processes it starts
+	// are terminated with it
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stderr, err := c.StderrPipe()
+	if err != nil {
+		log.Println("could not create new stderr pipe")
+		return err
+	}
+
+	stdoutPipe, err := c.StdoutPipe()
+	if err != nil {
+		log.Println("could not create new stdout pipe")
+		return err
+	}
+	stdout := w.stdout(stdoutPipe)
+
+	if err = c.Start(); err != nil {
+		log.Println("Start failed")
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			w.logOutput(COMMAND_STDERR_OUTPUT, stderr)
+			wg.Done()
+		}()
+		go func() {
+			w.logOutput(COMMAND_STDOUT_OUTPUT, stdout)
+			wg.Done()
+		}()
+
+		// All output has to be read before waiting for the command
+		wg.Wait()
+		done <- c.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if !d.isZero() {
+		timer := time.NewTimer(d.at.Sub(time.Now()))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		w.terminateLocal(c, done)
+		return d.err()
+	case <-w.killed:
+		w.terminateLocal(c, done)
+		return ErrKilled
+	}
+}
+
+// localEnv returns the environment of local commands: the allowed variables
+// of Applikatoni's environment and the env of the worker.
+func (w *Worker) localEnv() []string {
+	env := []string{}
+
+	for _, name := range localEnvAllowed {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	for name, value := range w.env {
+		env = append(env, name+"="+value)
+	}
+
+	return env
+}
+
+// terminateLocal sends SIGTERM to the process group of the command and SIGKILL
+// if it didn't exit within the grace period.
+func (w *Worker) terminateLocal(c *exec.Cmd, done <-chan error) {
+	pgid := -c.Process.Pid
+
+	err := syscall.Kill(pgid, syscall.SIGTERM)
+	if err != nil {
+		log.Println("could not send SIGTERM", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(w.gracePeriod):
+		err := syscall.Kill(pgid, syscall.SIGKILL)
+		if err != nil {
+			log.Println("could not send SIGKILL", err)
+		}
+		<-done
+	}
+}
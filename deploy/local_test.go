@@ -0,0 +1,121 @@
+package deploy
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func newTestLocalWorker() *Worker {
+	return &Worker{
+		host:        &models.Host{Name: models.LOCAL_HOST},
+		logger:      NewDeploymentLogger(&models.Deployment{}, nil),
+		gracePeriod: 50 * time.Millisecond,
+	}
+}
+
+func TestLocalWorkerExecute(t *testing.T) {
+	w := newTestLocalWorker()
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "echo {{out}}\necho {{err}} >&2"}
+
+	err := w.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	outputs := map[LogEntryType]string{}
+	for len(w.logger.ch) > 0 {
+		entry := <-w.logger.ch
+		if entry.Origin != models.LOCAL_HOST {
+			t.Errorf("wrong origin. want=%s, got=%s", models.LOCAL_HOST, entry.Origin)
+		}
+		outputs[entry.EntryType] += entry.Message
+	}
+
+	if outputs[COMMAND_STDOUT_OUTPUT] != "{{out}}\n" {
+		t.Errorf("wrong stdout. want=%q, got=%q", "{{out}}\n", outputs[COMMAND_STDOUT_OUTPUT])
+	}
+	if outputs[COMMAND_STDERR_OUTPUT] != "{{err}}\n" {
+		t.Errorf("wrong stderr. want=%q, got=%q", "{{err}}\n", outputs[COMMAND_STDERR_OUTPUT])
+	}
+}
+
+func TestLocalWorkerExecuteFailure(t *testing.T) {
+	w := newTestLocalWorker()
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "true\nexit 3\ntouch /should/not/run"}
+
+	result := w.Execute(preDeployment)
+	if result.err == nil {
+		t.Errorf("expected failing command to fail the stage")
+	}
+}
+
+func TestLocalWorkerExecuteStageTimeout(t *testing.T) {
+	w := newTestLocalWorker()
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "sleep 10"}
+	w.stageTimeouts = map[models.DeploymentStage]time.Duration{preDeployment: 50 * time.Millisecond}
+
+	start := time.Now()
+	result := w.Execute(preDeployment)
+
+	if _, ok := result.err.(*TimeoutError); !ok {
+		t.Fatalf("expected a TimeoutError, got=%v", result.err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("timed out command was not terminated")
+	}
+}
+
+func TestLocalWorkerExecuteEnv(t *testing.T) {
+	w := newTestLocalWorker()
+	w.env = map[string]string{"GREETING": "it's me"}
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "echo \"$GREETING\""}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	var stdout string
+	for len(w.logger.ch) > 0 {
+		entry := <-w.logger.ch
+		if entry.EntryType == COMMAND_STDOUT_OUTPUT {
+			stdout += entry.Message
+		}
+	}
+	if stdout != "it's me\n" {
+		t.Errorf("wrong stdout. want=%q, got=%q", "it's me\n", stdout)
+	}
+}
+
+func TestLocalWorkerExecuteEnvAllowed(t *testing.T) {
+	os.Setenv("APPLIKATONI_TEST_KEY", "top secret")
+	defer os.Unsetenv("APPLIKATONI_TEST_KEY")
+
+	w := newTestLocalWorker()
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "echo \"$APPLIKATONI_TEST_KEY\"; echo \"$PATH\""}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	var stdout string
+	for len(w.logger.ch) > 0 {
+		entry := <-w.logger.ch
+		if entry.EntryType == COMMAND_STDOUT_OUTPUT {
+			stdout += entry.Message
+		}
+	}
+	want := "\n" + os.Getenv("PATH") + "\n"
+	if stdout != want {
+		t.Errorf("wrong stdout. want=%q, got=%q", want, stdout)
+	}
+}
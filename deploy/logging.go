@@ -27,8 +27,13 @@ const (
 	STAGE_RESULT          LogEntryType = "STAGE_RESULT"
 	DEPLOYMENT_START      LogEntryType = "DEPLOYMENT_START"
 	DEPLOYMENT_SUCCESS    LogEntryType = "DEPLOYMENT_SUCCESS"
+	DEPLOYMENT_PARTIAL    LogEntryType = "DEPLOYMENT_PARTIAL"
 	DEPLOYMENT_FAIL       LogEntryType = "DEPLOYMENT_FAIL"
 	KILL_RECEIVED         LogEntryType = "KILL_RECEIVED"
+	DEPLOYMENT_KILLED     LogEntryType = "DEPLOYMENT_KILLED"
+	ROLLBACK_START        LogEntryType = "ROLLBACK_START"
+	ROLLBACK_SUCCESS      LogEntryType = "ROLLBACK_SUCCESS"
+	ROLLBACK_FAIL         LogEntryType = "ROLLBACK_FAIL"
 )
 
 type LogEntry struct {
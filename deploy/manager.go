@@ -1,10 +1,15 @@
 package deploy
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type Manager struct {
@@ -12,25 +17,67 @@ type Manager struct {
 
 	workers   []*Worker
 	sshConfig *ssh.ClientConfig
+	jumpHosts *jumpHostPool
+
+	// Only set if the local ssh-agent is forwarded to the hosts
+	sshAgent     agent.Agent
+	sshAgentConn net.Conn
 
 	logger *DeploymentLogger
 
-	killChan chan struct{}
+	// The name of whoever killed the deployment is sent on killChan
+	killChan chan string
+	// Closed as soon as the deployment is killed
+	killed   chan struct{}
+	killedBy string
+	killOnce sync.Once
+
+	rolledBack bool
+
+	// The health checks of the target, run from Applikatoni in the VERIFY
+	// stage, rendered with the script options, and the same health checks
+	// with everything the logger masks masked
+	healthChecks       []*models.HealthCheck
+	maskedHealthChecks []*models.HealthCheck
+	// Set if the deployment has a timeout
+	deadline deadline
 }
 
-func NewManager(c *models.DeploymentConfig, r *LogRouter, kc chan struct{}) (*Manager, error) {
-	ssh, err := newSSHClientConfig(c.User, c.SshKey)
+var ErrKilled = errors.New("Received kill signal")
+
+func NewManager(c *models.DeploymentConfig, r *LogRouter, kc chan string, hostKeys HostKeyStore) (*Manager, error) {
+	hostKeyCallback, err := newHostKeyCallback(c, hostKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := newSigner(c.SshKey, c.SshAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	redactPatterns, err := compileRedactPatterns(c.RedactPatterns)
 	if err != nil {
 		return nil, err
 	}
 
 	logger := NewDeploymentLogger(c.Deployment, r)
+	logger.MaskPatterns(redactPatterns...)
 
 	m := &Manager{
 		config:    c,
-		sshConfig: ssh,
+		sshConfig: newSSHClientConfig(c.User, signer, hostKeyCallback),
+		jumpHosts: newJumpHostPool(c.User, signer, hostKeyCallback),
 		logger:    logger,
 		killChan:  kc,
+		killed:    make(chan struct{}),
+	}
+
+	if c.SshAuth.ForwardAgent {
+		m.sshAgent, m.sshAgentConn, err = newLocalAgent()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err = m.assembleWorkers()
@@ -38,6 +85,15 @@ func NewManager(c *models.DeploymentConfig, r *LogRouter, kc chan struct{}) (*Ma
 		return nil, err
 	}
 
+	for _, hc := range c.HealthChecks {
+		rendered, err := hc.Render(c.ScriptOptions())
+		if err != nil {
+			return nil, err
+		}
+		m.healthChecks = append(m.healthChecks, rendered)
+		m.maskedHealthChecks = append(m.maskedHealthChecks, m.maskHealthCheck(rendered))
+	}
+
 	return m, nil
 }
 
@@ -51,6 +107,18 @@ func (m *Manager) AnnounceStart() {
 func (m *Manager) Start() error {
 	defer m.logger.Flush()
 
+	done := make(chan struct{})
+	watcherStopped := make(chan struct{})
+	go func() {
+		m.watchKill(done)
+		close(watcherStopped)
+	}()
+	// The watcher logs, so it has to be stopped before the logger is flushed
+	defer func() {
+		close(done)
+		<-watcherStopped
+	}()
+
 	err := m.connectWorkers()
 	if err != nil {
 		m.disconnectWorkers()
@@ -59,23 +127,172 @@ func (m *Manager) Start() error {
 	}
 	defer m.disconnectWorkers()
 
-	for _, stage := range m.config.Stages {
+	if m.config.Timeout > 0 {
+		m.setDeploymentDeadline(m.config.Timeout)
+	}
+
+	if m.config.Artifact != nil {
+		err := m.distributeArtifact()
+		if err != nil && !m.Killed() {
+			m.logger.LogDeploymentFail(err)
+			return err
+		}
+	}
+
+	for i, stage := range m.config.Stages {
+		if m.Killed() {
+			m.logSkippedStages(m.config.Stages[i:])
+			break
+		}
+
 		err := m.executeStage(stage)
-		if err != nil {
+		if err != nil && !m.Killed() {
 			m.logger.LogDeploymentFail(err)
+			m.rollback()
 			return err
 		}
 	}
 
+	if m.Killed() {
+		m.logger.LogDeploymentKilled(m.killedBy)
+		return ErrKilled
+	}
+
+	if failedHosts := m.FailedHosts(); len(failedHosts) > 0 {
+		m.logger.LogDeploymentPartialSuccess(failedHosts)
+		return nil
+	}
+
 	m.logger.LogDeploymentSuccess()
 	return nil
 }
 
+// FailedHosts returns the hosts, in the order of the hosts, that failed a
+// stage without failing the deployment, because of max_failed_hosts.
+func (m *Manager) FailedHosts() []string {
+	hosts := []string{}
+	for _, w := range m.workers {
+		if w.failed {
+			hosts = append(hosts, w.host.Name)
+		}
+	}
+	return hosts
+}
+
+// watchKill waits for a kill on the killChan until done is closed
+func (m *Manager) watchKill(done <-chan struct{}) {
+	for {
+		select {
+		case killer, ok := <-m.killChan:
+			if !ok {
+				return
+			}
+			m.kill(killer)
+		case <-done:
+			return
+		}
+	}
+}
+
+// kill makes the workers terminate their running commands and stops the
+// deployment before the next stage.
+func (m *Manager) kill(killer string) {
+	m.killOnce.Do(func() {
+		m.killedBy = killer
+		m.logger.LogKillReceived(killer)
+		close(m.killed)
+	})
+}
+
+// Killed reports whether the deployment was killed
+func (m *Manager) Killed() bool {
+	select {
+	case <-m.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+// KilledBy returns the name of whoever killed the deployment
+func (m *Manager) KilledBy() string {
+	if !m.Killed() {
+		return ""
+	}
+	return m.killedBy
+}
+
+func (m *Manager) logSkippedStages(stages []models.DeploymentStage) {
+	for _, stage := range stages {
+		m.logger.LogStageResult(fmt.Sprintf("applikatoni - stage %s skipped, deployment was killed", stage))
+	}
+}
+
+func (m *Manager) setDeploymentDeadline(timeout time.Duration) {
+	d := deadline{
+		at:     time.Now().Add(timeout),
+		reason: fmt.Sprintf("deployment timeout of %s exceeded", timeout),
+	}
+
+	m.deadline = d
+	for _, w := range m.workers {
+		w.deploymentDeadline = d
+	}
+}
+
+// RolledBack reports whether the hosts touched by a failed deployment were
+// successfully rolled back to the previous deployment.
+func (m *Manager) RolledBack() bool {
+	return m.rolledBack
+}
+
+func (m *Manager) rollback() {
+	workers := []*Worker{}
+	for _, w := range m.workers {
+		if w.touched && w.HasScript(models.STAGE_ROLLBACK) {
+			workers = append(workers, w)
+		}
+	}
+	if len(workers) == 0 {
+		return
+	}
+
+	if m.config.PreviousCommitSha == "" {
+		m.logger.LogRollbackFail(fmt.Errorf("no previous successful deployment to roll back to"))
+		return
+	}
+
+	m.logger.LogRollbackStart(m.config.PreviousCommitSha)
+
+	results := m.executeBatch(models.STAGE_ROLLBACK, workers)
+	rollbackFailed := false
+
+	for _, result := range results {
+		var msg string
+		if result.err != nil {
+			rollbackFailed = true
+			msg = fmtStageFailure(models.STAGE_ROLLBACK, result)
+		} else {
+			msg = fmtStageSuccess(models.STAGE_ROLLBACK, result)
+		}
+		m.logger.LogStageResult(msg)
+	}
+
+	if rollbackFailed {
+		m.logger.LogRollbackFail(fmt.Errorf("Execution of stage %s failed", models.STAGE_ROLLBACK))
+		return
+	}
+
+	m.rolledBack = true
+	m.logger.LogRollbackSuccess()
+}
+
 func (m *Manager) assembleWorkers() error {
 	configOptions := m.config.ScriptOptions()
+	rollbackOptions := m.config.RollbackScriptOptions()
 
 	for _, h := range m.config.Hosts {
-		w, err := m.newWorker(h, configOptions)
+		w, err := m.newWorker(h, configOptions, rollbackOptions)
 		if err != nil {
 			return err
 		}
@@ -101,6 +318,14 @@ func (m *Manager) disconnectWorkers() {
 	for _, w := range m.workers {
 		w.Close()
 	}
+
+	if m.jumpHosts != nil {
+		m.jumpHosts.Close()
+	}
+
+	if m.sshAgentConn != nil {
+		m.sshAgentConn.Close()
+	}
 }
 
 func (m *Manager) executeStage(stage models.DeploymentStage) error {
@@ -108,16 +333,18 @@ func (m *Manager) executeStage(stage models.DeploymentStage) error {
 
 	m.logger.LogStageStart(stage)
 
-	results := m.executeWorkersStage(stage)
-	stageFailed := false
+	maxFailed := m.config.MaxFailedHosts[stage].MaxFailedHosts(m.stageHostCount(stage))
+
+	results := m.executeWorkersStage(stage, maxFailed)
+	if stage == models.STAGE_VERIFY && len(m.healthChecks) > 0 && m.tolerable(results, maxFailed) {
+		results = append(results, m.executeHealthChecks())
+	}
 
 	for i := 0; i < len(results); i++ {
 		var msg string
 
 		result := results[i]
 		if result.err != nil {
-			stageFailed = true
-
 			msg = fmtStageFailure(stage, result)
 		} else {
 			if result.skipped {
@@ -129,17 +356,161 @@ func (m *Manager) executeStage(stage models.DeploymentStage) error {
 		m.logger.LogStageResult(msg)
 	}
 
-	if stageFailed {
+	if !m.tolerable(results, maxFailed) {
 		m.logger.LogStageFail(stage)
 		err := fmt.Errorf("Execution of stage %s failed", stageName)
 		return err
 	}
 
+	if containsFailure(results) {
+		m.markFailedHosts(stage, results, maxFailed)
+	}
+
 	m.logger.LogStageSuccess(stage)
 	return nil
 }
 
-func (m *Manager) executeWorkersStage(stage models.DeploymentStage) []ExecutionResult {
+// stageHostCount returns the number of hosts that execute the stage, which
+// max_failed_hosts in percent refers to.
+func (m *Manager) stageHostCount(stage models.DeploymentStage) int {
+	count := 0
+	for _, w := range m.workers {
+		if w.HasScript(stage) && !w.failed {
+			count++
+		}
+	}
+	return count
+}
+
+// tolerable reports whether no more than maxFailed hosts failed. Failures of
+// Applikatoni itself, e.g. of the target's health checks, and failures after
+// the deployment was killed are never tolerated.
+func (m *Manager) tolerable(results []ExecutionResult, maxFailed int) bool {
+	if m.Killed() {
+		return !containsFailure(results)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		if r.origin == "applikatoni" {
+			return false
+		}
+		failed++
+	}
+
+	return failed <= maxFailed
+}
+
+// markFailedHosts marks the workers of the hosts that failed the stage, so
+// they are skipped in the following stages.
+func (m *Manager) markFailedHosts(stage models.DeploymentStage, results []ExecutionResult, maxFailed int) {
+	failed := map[string]bool{}
+	for _, r := range results {
+		if r.err != nil {
+			failed[r.origin] = true
+		}
+	}
+
+	for _, w := range m.workers {
+		if failed[w.host.Name] {
+			w.failed = true
+		}
+	}
+
+	m.logger.LogStageResult(fmt.Sprintf("applikatoni - %d host(s) failed stage %s, tolerated by max_failed_hosts of %d", len(failed), stage, maxFailed))
+}
+
+func (m *Manager) executeWorkersStage(stage models.DeploymentStage, maxFailed int) []ExecutionResult {
+	results := []ExecutionResult{}
+
+	workers := []*Worker{}
+	for _, w := range m.workers {
+		if w.HasScript(stage) && !w.failed {
+			workers = append(workers, w)
+		} else {
+			results = append(results, ExecutionResult{origin: w.host.Name, skipped: true})
+		}
+	}
+
+	workers, runOnceResults := m.selectRunOnceWorkers(stage, workers)
+	results = append(results, runOnceResults...)
+	if containsFailure(runOnceResults) {
+		return append(results, skippedResults([][]*Worker{workers})...)
+	}
+
+	batches := splitIntoBatches(workers, m.config.Rollout.HostsPerBatch(len(workers)))
+	for i, batch := range batches {
+		if i > 0 {
+			err := m.pauseBetweenBatches()
+			if err != nil {
+				results = append(results, ExecutionResult{origin: "applikatoni", err: err})
+				results = append(results, skippedResults(batches[i:])...)
+				break
+			}
+		}
+
+		batchResults := m.executeBatch(stage, batch)
+		results = append(results, batchResults...)
+
+		// Fail fast: if more hosts failed than the stage tolerates, the
+		// hosts in the remaining batches are not touched.
+		if !m.tolerable(results, maxFailed) {
+			results = append(results, skippedResults(batches[i+1:])...)
+			break
+		}
+	}
+
+	return results
+}
+
+// selectRunOnceWorkers keeps only one worker per role of the workers that
+// execute the stage once for their role: the first one, in the order of the
+// hosts, whose connection is alive. The chosen hosts are logged, the other
+// workers are skipped. If no host of a role is alive, a failed result is
+// returned for that role.
+func (m *Manager) selectRunOnceWorkers(stage models.DeploymentStage, workers []*Worker) ([]*Worker, []ExecutionResult) {
+	selected := []*Worker{}
+	results := []ExecutionResult{}
+
+	chosen := map[string]*Worker{}
+	roles := []string{}
+
+	for _, w := range workers {
+		role, ok := w.runOnce[stage]
+		if !ok {
+			selected = append(selected, w)
+			continue
+		}
+
+		if _, seen := chosen[role]; !seen {
+			chosen[role] = nil
+			roles = append(roles, role)
+		}
+
+		if chosen[role] != nil || !w.Alive() {
+			results = append(results, ExecutionResult{origin: w.host.Name, skipped: true})
+			continue
+		}
+
+		chosen[role] = w
+		selected = append(selected, w)
+		m.logger.LogStageResult(fmt.Sprintf("applikatoni - stage %s of role %s runs once, on %s", stage, role, w.host.Name))
+	}
+
+	for _, role := range roles {
+		if chosen[role] == nil {
+			err := fmt.Errorf("no reachable host with role %s to run stage %s on", role, stage)
+			results = append(results, ExecutionResult{origin: "applikatoni", err: err})
+		}
+	}
+
+	return selected, results
+}
+
+func (m *Manager) executeBatch(stage models.DeploymentStage, batch []*Worker) []ExecutionResult {
 	results := []ExecutionResult{}
 	ch := make(chan ExecutionResult)
 
@@ -147,40 +518,186 @@ func (m *Manager) executeWorkersStage(stage models.DeploymentStage) []ExecutionR
 		ch <- w.Execute(stage)
 	}
 
-	for _, w := range m.workers {
+	for _, w := range batch {
 		go exec(w)
 	}
 
-	for i := 0; i < len(m.workers); i++ {
-		select {
-		case result := <-ch:
-			results = append(results, result)
-		case <-m.killChan:
-			// Received kill first. Log this, add result, wait for worker
-			m.logger.LogKillReceived()
-			errMsg := fmt.Errorf("Received kill signal")
-			results = append(results, ExecutionResult{origin: "applikatoni", err: errMsg})
-
-			result := <-ch
-			results = append(results, result)
-		}
+	// If the deployment is killed, the workers terminate their running
+	// commands and return
+	for i := 0; i < len(batch); i++ {
+		results = append(results, <-ch)
 	}
 
 	return results
 }
 
-func (m *Manager) newWorker(h *models.Host, scriptOptions map[string]string) (*Worker, error) {
+// executeHealthChecks runs the health checks of the target, after the hosts
+// passed theirs.
+func (m *Manager) executeHealthChecks() ExecutionResult {
+	checker := &healthChecker{
+		origin: "applikatoni",
+		logger: m.logger,
+		killed: m.killed,
+	}
+
+	start := time.Now()
+	err := checker.run(m.healthChecks, m.maskedHealthChecks, m.deadline)
+
+	return ExecutionResult{origin: "applikatoni", err: err, timeTaken: time.Since(start)}
+}
+
+// maskHealthCheck returns a copy of the rendered health check of the target
+// with the secret values and the matches of the redact patterns in its URL
+// and ExpectedBody masked.
+func (m *Manager) maskHealthCheck(hc *models.HealthCheck) *models.HealthCheck {
+	masked := *hc
+	masked.URL = m.logger.redactor.redact(hc.URL)
+	masked.ExpectedBody = m.logger.redactor.redact(hc.ExpectedBody)
+	return &masked
+}
+
+func (m *Manager) pauseBetweenBatches() error {
+	pause := m.config.Rollout.Pause()
+	if pause == 0 {
+		return nil
+	}
+
+	m.logger.LogStageResult(fmt.Sprintf("applikatoni - waiting %s before starting the next batch", pause))
+
+	select {
+	case <-time.After(pause):
+		return nil
+	case <-m.killed:
+		return ErrKilled
+	}
+}
+
+func (m *Manager) newWorker(h *models.Host, scriptOptions, rollbackOptions map[string]string) (*Worker, error) {
 	roles, err := findHostRoles(h, m.config.Roles)
 	if err != nil {
 		return nil, err
 	}
 
+	scripts, err := mergeRoleScripts(roles, scriptOptions, rollbackOptions, (*models.Role).RenderScripts)
+	if err != nil {
+		return nil, err
+	}
+
+	maskedScripts, err := mergeRoleScripts(roles, scriptOptions, rollbackOptions, (*models.Role).RenderMaskedScripts)
+	if err != nil {
+		return nil, err
+	}
+
+	healthCheckOptions := hostOptions(h, scriptOptions)
+
+	healthChecks, err := mergeRoleHealthChecks(roles, healthCheckOptions, (*models.Role).RenderHealthChecks)
+	if err != nil {
+		return nil, err
+	}
+
+	maskedHealthChecks, err := mergeRoleHealthChecks(roles, healthCheckOptions, (*models.Role).RenderMaskedHealthChecks)
+	if err != nil {
+		return nil, err
+	}
+
+	runOnce, err := mergeRunOnceStages(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	shellStages, err := mergeShellStages(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	env, secrets, err := models.ResolveEnv(mergeEnv(m.config.Env, roles), m.config.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.MaskValues(secrets...)
+	for _, role := range roles {
+		m.logger.MaskValues(role.SecretValues(scriptOptions)...)
+		m.logger.MaskValues(role.SecretValues(rollbackOptions)...)
+	}
+
+	w := &Worker{
+		host:               h,
+		scripts:            scripts,
+		maskedScripts:      maskedScripts,
+		runOnce:            runOnce,
+		continueOnError:    mergeContinueOnError(roles),
+		shellStages:        shellStages,
+		env:                env,
+		healthChecks:       healthChecks,
+		maskedHealthChecks: maskedHealthChecks,
+		stageTimeouts:      mergeStageTimeouts(roles),
+		sshConfig:          m.sshConfig,
+		jumpHosts:          m.jumpHosts,
+		jumpChain:          m.jumpChain(h),
+		sshAgent:           m.sshAgent,
+		killed:             m.killed,
+		gracePeriod:        m.config.KillGracePeriod,
+		logger:             m.logger,
+	}
+	return w, nil
+}
+
+// jumpChain returns the jump hosts through which the connection to h is
+// tunneled. The jump hosts of the host take precedence over the target's.
+func (m *Manager) jumpChain(h *models.Host) []*models.JumpHost {
+	if h.JumpHosts != nil {
+		return h.JumpHosts
+	}
+	return m.config.JumpHosts
+}
+
+// Scripts returns the rendered scripts, with secret options masked, of the
+// stages selected for this deployment and the ROLLBACK stage, in the order of
+// the hosts.
+func (m *Manager) Scripts() []*models.DeploymentScript {
+	stages := append([]models.DeploymentStage{}, m.config.Stages...)
+	stages = append(stages, models.STAGE_ROLLBACK)
+
+	scripts := []*models.DeploymentScript{}
+	for _, w := range m.workers {
+		for _, stage := range stages {
+			script, ok := w.maskedScripts[stage]
+			if !ok {
+				continue
+			}
+
+			scripts = append(scripts, &models.DeploymentScript{
+				DeploymentId: m.config.Deployment.Id,
+				Host:         w.host.Name,
+				Stage:        stage,
+				Script:       script,
+			})
+		}
+	}
+
+	return scripts
+}
+
+type renderFunc func(*models.Role, map[string]string) (map[models.DeploymentStage]string, error)
+
+func mergeRoleScripts(roles []*models.Role, scriptOptions, rollbackOptions map[string]string, render renderFunc) (map[models.DeploymentStage]string, error) {
 	rolesScripts := []map[models.DeploymentStage]string{}
 	for _, r := range roles {
-		s, err := r.RenderScripts(scriptOptions)
+		s, err := render(r, scriptOptions)
 		if err != nil {
 			return nil, err
 		}
+
+		// The ROLLBACK script needs to point to the previous commit, so it
+		// is rendered with its own options
+		if _, ok := s[models.STAGE_ROLLBACK]; ok {
+			rollbackScripts, err := render(r, rollbackOptions)
+			if err != nil {
+				return nil, err
+			}
+			s[models.STAGE_ROLLBACK] = rollbackScripts[models.STAGE_ROLLBACK]
+		}
+
 		rolesScripts = append(rolesScripts, s)
 	}
 
@@ -195,13 +712,149 @@ func (m *Manager) newWorker(h *models.Host, scriptOptions map[string]string) (*W
 		}
 	}
 
-	w := &Worker{
-		host:      h,
-		scripts:   mergedScripts,
-		sshConfig: m.sshConfig,
-		logger:    m.logger,
+	return mergedScripts, nil
+}
+
+// mergeRunOnceStages returns the stages the roles execute on only one host,
+// mapped to the name of the role, if the role has a script for the stage.
+// Hosts that are not chosen skip the whole script of the stage, so it's an
+// error if another role of the host has a script for a run once stage.
+func mergeRunOnceStages(roles []*models.Role) (map[models.DeploymentStage]string, error) {
+	runOnce := make(map[models.DeploymentStage]string)
+
+	for _, r := range roles {
+		for _, stage := range r.RunOnce {
+			if _, ok := r.ScriptTemplates[stage]; ok {
+				runOnce[stage] = r.Name
+			}
+		}
 	}
-	return w, nil
+
+	for _, r := range roles {
+		for stage := range r.ScriptTemplates {
+			if role, ok := runOnce[stage]; ok && role != r.Name {
+				return nil, fmt.Errorf("stage %s runs once for role %s, but role %s has a script for it too", stage, role, r.Name)
+			}
+		}
+	}
+
+	return runOnce, nil
+}
+
+// mergeContinueOnError returns the command prefixes whose failure doesn't stop
+// the script of a stage, if the role has a script for the stage.
+func mergeContinueOnError(roles []*models.Role) map[models.DeploymentStage][]string {
+	continueOnError := make(map[models.DeploymentStage][]string)
+
+	for _, r := range roles {
+		for stage, prefixes := range r.ContinueOnError {
+			if _, ok := r.ScriptTemplates[stage]; ok {
+				continueOnError[stage] = append(continueOnError[stage], prefixes...)
+			}
+		}
+	}
+
+	return continueOnError
+}
+
+// mergeShellStages returns the stages whose script belongs to a role with the
+// shell script mode. The script mode belongs to the role, so it's an error if
+// roles of the host with different script modes have a script for the same
+// stage.
+func mergeShellStages(roles []*models.Role) (map[models.DeploymentStage]bool, error) {
+	shellStages := make(map[models.DeploymentStage]bool)
+	modeRoles := make(map[models.DeploymentStage]*models.Role)
+
+	for _, r := range roles {
+		shell := r.ScriptMode == models.SCRIPT_MODE_SHELL
+		for stage := range r.ScriptTemplates {
+			if other, ok := modeRoles[stage]; ok && shellStages[stage] != shell {
+				return nil, fmt.Errorf("roles %s and %s use different script modes for %s", other.Name, r.Name, stage)
+			}
+			modeRoles[stage] = r
+			shellStages[stage] = shell
+		}
+	}
+
+	for stage, shell := range shellStages {
+		if !shell {
+			delete(shellStages, stage)
+		}
+	}
+
+	return shellStages, nil
+}
+
+// mergeEnv returns the env of the target, overridden by the env of the roles
+// in their order.
+func mergeEnv(targetEnv map[string]string, roles []*models.Role) map[string]string {
+	env := map[string]string{}
+
+	for name, value := range targetEnv {
+		env[name] = value
+	}
+	for _, r := range roles {
+		for name, value := range r.Env {
+			env[name] = value
+		}
+	}
+
+	return env
+}
+
+type renderHealthChecksFunc func(*models.Role, map[string]string) ([]*models.HealthCheck, error)
+
+// mergeRoleHealthChecks returns the rendered health checks of all roles, in
+// the order of the roles.
+func mergeRoleHealthChecks(roles []*models.Role, options map[string]string, render renderHealthChecksFunc) ([]*models.HealthCheck, error) {
+	checks := []*models.HealthCheck{}
+
+	for _, r := range roles {
+		rendered, err := render(r, options)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, rendered...)
+	}
+
+	return checks, nil
+}
+
+// hostOptions returns a copy of the options with the name of the host, without
+// the port, added as Host.
+func hostOptions(h *models.Host, options map[string]string) map[string]string {
+	hostOptions := map[string]string{}
+	for key, value := range options {
+		hostOptions[key] = value
+	}
+
+	hostname, _, err := net.SplitHostPort(h.Name)
+	if err != nil {
+		hostname = h.Name
+	}
+	hostOptions["Host"] = hostname
+
+	return hostOptions
+}
+
+// mergeStageTimeouts returns the timeouts of the stages of all roles. If more
+// than one role sets a timeout for a stage, the shortest one is used.
+func mergeStageTimeouts(roles []*models.Role) map[models.DeploymentStage]time.Duration {
+	timeouts := make(map[models.DeploymentStage]time.Duration)
+
+	for _, r := range roles {
+		for stage := range r.StageTimeouts {
+			timeout := r.StageTimeout(stage)
+			if timeout <= 0 {
+				continue
+			}
+			if current, ok := timeouts[stage]; !ok || timeout < current {
+				timeouts[stage] = timeout
+			}
+		}
+	}
+
+	return timeouts
 }
 
 func findHostRoles(h *models.Host, roles []*models.Role) ([]*models.Role, error) {
@@ -236,3 +889,39 @@ func fmtStageFailure(s models.DeploymentStage, r ExecutionResult) string {
 func fmtStageSkipped(s models.DeploymentStage, r ExecutionResult) string {
 	return fmt.Sprintf("%s - stage %s skipped", r.origin, string(s))
 }
+
+func splitIntoBatches(workers []*Worker, batchSize int) [][]*Worker {
+	batches := [][]*Worker{}
+	if batchSize < 1 {
+		return batches
+	}
+
+	for start := 0; start < len(workers); start += batchSize {
+		end := start + batchSize
+		if end > len(workers) {
+			end = len(workers)
+		}
+		batches = append(batches, workers[start:end])
+	}
+
+	return batches
+}
+
+func skippedResults(batches [][]*Worker) []ExecutionResult {
+	results := []ExecutionResult{}
+	for _, batch := range batches {
+		for _, w := range batch {
+			results = append(results, ExecutionResult{origin: w.host.Name, skipped: true})
+		}
+	}
+	return results
+}
+
+func containsFailure(results []ExecutionResult) bool {
+	for _, r := range results {
+		if r.err != nil {
+			return true
+		}
+	}
+	return false
+}
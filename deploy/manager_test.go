@@ -2,6 +2,7 @@ package deploy
 
 import (
 	"testing"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 )
@@ -191,14 +192,14 @@ var newWorkerTests = []struct {
 
 func TestNewWorker(t *testing.T) {
 	testLogger := &DeploymentLogger{}
-	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"))
+	testSshConfig := newSSHClientConfig("testuser", nil, nil)
 	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
 
 	for _, tt := range newWorkerTests {
 		testConfig := &models.DeploymentConfig{Roles: tt.roles}
 		testManager.config = testConfig
 
-		w, err := testManager.newWorker(tt.host, tt.scriptOptions)
+		w, err := testManager.newWorker(tt.host, tt.scriptOptions, tt.scriptOptions)
 		if err != nil {
 			t.Error(err)
 		}
@@ -223,9 +224,36 @@ func TestNewWorker(t *testing.T) {
 	}
 }
 
+func TestNewWorkerMasksSecretOptions(t *testing.T) {
+	testManager := &Manager{logger: NewDeploymentLogger(deployment, nil)}
+	testManager.config = &models.DeploymentConfig{
+		Roles: []*models.Role{
+			&models.Role{
+				Name:            "web",
+				ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "deploy --token {{.ApiToken}}"},
+				Options:         map[string]string{"ApiToken": "s3cr3t"},
+				SecretOptions:   []string{"ApiToken"},
+			},
+		},
+	}
+	host := &models.Host{Name: "web1:22", Roles: []string{"web"}}
+
+	w, err := testManager.newWorker(host, map[string]string{}, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.logCommandStart(w.scripts[preDeployment])
+
+	entry := <-testManager.logger.ch
+	if entry.Message != "deploy --token ****" {
+		t.Errorf("secret option not masked. want=%q, got=%q", "deploy --token ****", entry.Message)
+	}
+}
+
 func TestNewWorkerError(t *testing.T) {
 	testLogger := &DeploymentLogger{}
-	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"))
+	testSshConfig := newSSHClientConfig("testuser", nil, nil)
 	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
 
 	// Two roles that define scripts for the same stage
@@ -256,7 +284,7 @@ func TestNewWorkerError(t *testing.T) {
 	testConfig := &models.DeploymentConfig{Roles: roles}
 	testManager.config = testConfig
 
-	w, err := testManager.newWorker(host, scriptOptions)
+	w, err := testManager.newWorker(host, scriptOptions, scriptOptions)
 	if err == nil {
 		t.Errorf("newWorker expected to return error, but didn't")
 	}
@@ -264,3 +292,516 @@ func TestNewWorkerError(t *testing.T) {
 		t.Errorf("newWorker expected to not return a new worker, but did")
 	}
 }
+
+func TestNewWorkerRollbackScript(t *testing.T) {
+	testManager := &Manager{logger: &DeploymentLogger{}}
+	testManager.config = &models.DeploymentConfig{
+		Roles: []*models.Role{
+			&models.Role{
+				Name: "web",
+				ScriptTemplates: map[models.DeploymentStage]string{
+					preDeployment:         "checkout {{.CommitSha}}",
+					models.STAGE_ROLLBACK: "checkout {{.CommitSha}} instead of {{.FailedCommitSha}}",
+				},
+			},
+		},
+	}
+
+	host := &models.Host{Name: "webcluster.applikatoni.com", Roles: []string{"web"}}
+	scriptOptions := map[string]string{"CommitSha": "n3w"}
+	rollbackOptions := map[string]string{"CommitSha": "0ld", "FailedCommitSha": "n3w"}
+
+	w, err := testManager.newWorker(host, scriptOptions, rollbackOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[models.DeploymentStage]string{
+		preDeployment:         "checkout n3w",
+		models.STAGE_ROLLBACK: "checkout 0ld instead of n3w",
+	}
+	for stage, script := range expected {
+		if w.scripts[stage] != script {
+			t.Errorf("wrong %s script. want=%q, got=%q", stage, script, w.scripts[stage])
+		}
+	}
+}
+
+func TestJumpChain(t *testing.T) {
+	targetJumpHosts := []*models.JumpHost{{Name: "bastion.applikatoni.com:22"}}
+	hostJumpHosts := []*models.JumpHost{{Name: "other-bastion.applikatoni.com:22"}}
+
+	testManager := &Manager{
+		config: &models.DeploymentConfig{JumpHosts: targetJumpHosts},
+	}
+
+	tests := []struct {
+		host     *models.Host
+		expected []*models.JumpHost
+	}{
+		{&models.Host{Name: "web.applikatoni.com:22"}, targetJumpHosts},
+		{&models.Host{Name: "web.applikatoni.com:22", JumpHosts: hostJumpHosts}, hostJumpHosts},
+		{&models.Host{Name: "web.applikatoni.com:22", JumpHosts: []*models.JumpHost{}}, []*models.JumpHost{}},
+	}
+
+	for _, tt := range tests {
+		chain := testManager.jumpChain(tt.host)
+		if len(chain) != len(tt.expected) {
+			t.Errorf("wrong jump chain length. want=%d, got=%d", len(tt.expected), len(chain))
+			continue
+		}
+		for i := range chain {
+			if chain[i] != tt.expected[i] {
+				t.Errorf("wrong jump host. want=%+v, got=%+v", tt.expected[i], chain[i])
+			}
+		}
+	}
+}
+
+func TestManagerScripts(t *testing.T) {
+	testManager := &Manager{
+		config: &models.DeploymentConfig{
+			Deployment: &models.Deployment{Id: 42},
+			Stages:     []models.DeploymentStage{"SECOND", "FIRST"},
+		},
+		workers: []*Worker{
+			&Worker{host: testHosts[0], maskedScripts: map[models.DeploymentStage]string{
+				"FIRST":               "first ****",
+				"SECOND":              "second",
+				"UNSELECTED":          "unselected",
+				models.STAGE_ROLLBACK: "rollback",
+			}},
+			&Worker{host: testHosts[1], maskedScripts: map[models.DeploymentStage]string{
+				"FIRST": "first ****",
+			}},
+		},
+	}
+
+	expected := []models.DeploymentScript{
+		{DeploymentId: 42, Host: testHosts[0].Name, Stage: "SECOND", Script: "second"},
+		{DeploymentId: 42, Host: testHosts[0].Name, Stage: "FIRST", Script: "first ****"},
+		{DeploymentId: 42, Host: testHosts[0].Name, Stage: models.STAGE_ROLLBACK, Script: "rollback"},
+		{DeploymentId: 42, Host: testHosts[1].Name, Stage: "FIRST", Script: "first ****"},
+	}
+
+	scripts := testManager.Scripts()
+	if len(scripts) != len(expected) {
+		t.Fatalf("wrong number of scripts. want=%d, got=%d", len(expected), len(scripts))
+	}
+
+	for i, s := range scripts {
+		if *s != expected[i] {
+			t.Errorf("wrong script. want=%+v, got=%+v", expected[i], *s)
+		}
+	}
+}
+
+func TestRollbackSkipsUntouchedWorkers(t *testing.T) {
+	rollbackScripts := map[models.DeploymentStage]string{models.STAGE_ROLLBACK: "rollback"}
+	testManager := &Manager{
+		logger: &DeploymentLogger{},
+		config: &models.DeploymentConfig{PreviousCommitSha: "0ld"},
+		workers: []*Worker{
+			&Worker{host: testHosts[0], scripts: rollbackScripts},
+			&Worker{host: testHosts[1], scripts: rollbackScripts},
+		},
+	}
+
+	testManager.rollback()
+
+	if testManager.RolledBack() {
+		t.Errorf("expected no rollback if no worker was touched")
+	}
+}
+
+func TestSplitIntoBatches(t *testing.T) {
+	workers := []*Worker{}
+	for _, h := range testHosts {
+		workers = append(workers, &Worker{host: h})
+	}
+
+	tests := []struct {
+		batchSize     int
+		expectedSizes []int
+	}{
+		{1, []int{1, 1, 1}},
+		{2, []int{2, 1}},
+		{3, []int{3}},
+		{0, []int{}},
+	}
+
+	for _, tt := range tests {
+		batches := splitIntoBatches(workers, tt.batchSize)
+		if len(batches) != len(tt.expectedSizes) {
+			t.Errorf("wrong number of batches. want=%d, got=%d", len(tt.expectedSizes), len(batches))
+			continue
+		}
+
+		seen := 0
+		for i, batch := range batches {
+			if len(batch) != tt.expectedSizes[i] {
+				t.Errorf("batch %d has wrong size. want=%d, got=%d", i, tt.expectedSizes[i], len(batch))
+			}
+			for _, w := range batch {
+				if w != workers[seen] {
+					t.Errorf("batches are not in host order. want=%s, got=%s", workers[seen].host.Name, w.host.Name)
+				}
+				seen++
+			}
+		}
+	}
+}
+
+func TestExecuteWorkersStageSkipsHostsWithoutScript(t *testing.T) {
+	testManager := &Manager{
+		logger: &DeploymentLogger{},
+		config: &models.DeploymentConfig{
+			Rollout: &models.RolloutStrategy{BatchSize: 1},
+		},
+		workers: []*Worker{
+			&Worker{host: testHosts[0], scripts: map[models.DeploymentStage]string{}},
+			&Worker{host: testHosts[1], scripts: map[models.DeploymentStage]string{}},
+		},
+	}
+
+	results := testManager.executeWorkersStage(preDeployment, 0)
+	if len(results) != 2 {
+		t.Fatalf("wrong number of results. want=%d, got=%d", 2, len(results))
+	}
+	for _, r := range results {
+		if !r.skipped {
+			t.Errorf("expected result of %s to be skipped", r.origin)
+		}
+	}
+}
+
+func TestMergeStageTimeouts(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{StageTimeouts: map[models.DeploymentStage]int{preDeployment: 60, migrate: 300}},
+		&models.Role{StageTimeouts: map[models.DeploymentStage]int{preDeployment: 30, models.STAGE_ROLLBACK: 0}},
+	}
+
+	timeouts := mergeStageTimeouts(roles)
+
+	expected := map[models.DeploymentStage]time.Duration{
+		preDeployment: 30 * time.Second,
+		migrate:       5 * time.Minute,
+	}
+	if len(timeouts) != len(expected) {
+		t.Errorf("wrong number of timeouts. want=%d, got=%d", len(expected), len(timeouts))
+	}
+	for stage, timeout := range expected {
+		if timeouts[stage] != timeout {
+			t.Errorf("wrong timeout for %s. want=%s, got=%s", stage, timeout, timeouts[stage])
+		}
+	}
+}
+
+func TestManagerStartKilled(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	router := NewLogRouter()
+	router.Start()
+	defer router.Stop()
+
+	deployment := &models.Deployment{Id: 42}
+	logger := NewDeploymentLogger(deployment, router)
+	logger.BroadcastLogs()
+
+	killChan := make(chan string, 1)
+	testManager := &Manager{
+		config: &models.DeploymentConfig{
+			Deployment: deployment,
+			Stages:     []models.DeploymentStage{preDeployment, migrate},
+		},
+		logger:   logger,
+		killChan: killChan,
+		killed:   make(chan struct{}),
+	}
+
+	w := newTestWorker(t, s)
+	w.logger = logger
+	w.killed = testManager.killed
+	w.scripts = map[models.DeploymentStage]string{
+		preDeployment: "hang",
+		migrate:       "migrate",
+	}
+	testManager.workers = []*Worker{w}
+
+	go func() {
+		<-s.commands
+		killChan <- "mrnugget"
+	}()
+
+	err := testManager.Start()
+	if err != ErrKilled {
+		t.Fatalf("wrong error. want=%s, got=%v", ErrKilled, err)
+	}
+
+	if !testManager.Killed() {
+		t.Errorf("expected manager to be killed")
+	}
+	if testManager.KilledBy() != "mrnugget" {
+		t.Errorf("wrong killer. want=%s, got=%s", "mrnugget", testManager.KilledBy())
+	}
+	if testManager.RolledBack() {
+		t.Errorf("expected no rollback of a killed deployment")
+	}
+
+	select {
+	case cmd := <-s.commands:
+		t.Errorf("command of remaining stage executed: %s", cmd)
+	default:
+	}
+}
+
+func TestMergeRunOnceStages(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{
+			Name:            "web",
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake db:migrate"},
+			RunOnce:         []models.DeploymentStage{migrate, preDeployment},
+		},
+		&models.Role{
+			Name:            "workers",
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "stop"},
+		},
+	}
+
+	runOnce, err := mergeRunOnceStages(roles)
+	if err != nil {
+		t.Fatalf("expected no error, got=%s", err)
+	}
+
+	if len(runOnce) != 1 {
+		t.Errorf("wrong number of run once stages. want=%d, got=%d", 1, len(runOnce))
+	}
+	if runOnce[migrate] != "web" {
+		t.Errorf("wrong role for %s. want=%s, got=%s", migrate, "web", runOnce[migrate])
+	}
+}
+
+func TestMergeRunOnceStagesSharedStage(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{
+			Name:            "web",
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake db:migrate"},
+			RunOnce:         []models.DeploymentStage{migrate},
+		},
+		&models.Role{
+			Name:            "workers",
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake jobs:migrate"},
+		},
+	}
+
+	_, err := mergeRunOnceStages(roles)
+	if err == nil {
+		t.Errorf("expected an error for a run once stage with scripts of two roles")
+	}
+}
+
+func TestSelectRunOnceWorkersMultipleRoles(t *testing.T) {
+	s1 := newTestSSHServer(t)
+	defer s1.Close()
+	s2 := newTestSSHServer(t)
+	defer s2.Close()
+
+	postDeployment := models.DeploymentStage("POST_DEPLOYMENT")
+	roles := []*models.Role{
+		&models.Role{
+			Name:            "web",
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake db:migrate"},
+			RunOnce:         []models.DeploymentStage{migrate},
+		},
+		&models.Role{
+			Name:            "workers",
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "stop", postDeployment: "start"},
+			RunOnce:         []models.DeploymentStage{postDeployment},
+		},
+	}
+
+	// Both hosts have both roles
+	first := newTestWorker(t, s1)
+	defer first.Close()
+	second := newTestWorker(t, s2)
+	defer second.Close()
+	for _, w := range []*Worker{first, second} {
+		runOnce, err := mergeRunOnceStages(roles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.runOnce = runOnce
+	}
+
+	testManager := &Manager{logger: NewDeploymentLogger(&models.Deployment{}, nil)}
+
+	tests := []struct {
+		stage    models.DeploymentStage
+		expected []*Worker
+	}{
+		{migrate, []*Worker{first}},
+		{preDeployment, []*Worker{first, second}},
+		{postDeployment, []*Worker{first}},
+	}
+
+	for _, tt := range tests {
+		selected, results := testManager.selectRunOnceWorkers(tt.stage, []*Worker{first, second})
+		if containsFailure(results) {
+			t.Errorf("%s: unexpected failure: %+v", tt.stage, results)
+		}
+		if len(selected) != len(tt.expected) {
+			t.Errorf("%s: wrong workers selected. want=%d, got=%d", tt.stage, len(tt.expected), len(selected))
+			continue
+		}
+		for i, w := range tt.expected {
+			if selected[i] != w {
+				t.Errorf("%s: wrong worker selected at %d", tt.stage, i)
+			}
+		}
+	}
+}
+
+func TestSelectRunOnceWorkers(t *testing.T) {
+	s1 := newTestSSHServer(t)
+	defer s1.Close()
+	s2 := newTestSSHServer(t)
+	defer s2.Close()
+
+	runOnce := map[models.DeploymentStage]string{migrate: "web"}
+
+	unreachable := &Worker{host: testHosts[0], runOnce: runOnce}
+	first := newTestWorker(t, s1)
+	defer first.Close()
+	first.runOnce = runOnce
+	second := newTestWorker(t, s2)
+	defer second.Close()
+	second.runOnce = runOnce
+	other := &Worker{host: testHosts[2]}
+
+	testManager := &Manager{logger: NewDeploymentLogger(&models.Deployment{}, nil)}
+
+	selected, results := testManager.selectRunOnceWorkers(migrate, []*Worker{unreachable, first, second, other})
+
+	if len(selected) != 2 || selected[0] != first || selected[1] != other {
+		t.Errorf("wrong workers selected: %v", selected)
+	}
+	if len(results) != 2 || !results[0].skipped || !results[1].skipped {
+		t.Errorf("expected two skipped results, got=%+v", results)
+	}
+
+	selected, results = testManager.selectRunOnceWorkers(migrate, []*Worker{unreachable})
+	if len(selected) != 0 {
+		t.Errorf("expected no worker selected, got=%v", selected)
+	}
+	if !containsFailure(results) {
+		t.Errorf("expected a failure if no host of the role is reachable")
+	}
+}
+
+func TestMergeContinueOnError(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "stop"},
+			ContinueOnError: map[models.DeploymentStage][]string{preDeployment: []string{"stop"}, migrate: []string{"rake"}},
+		},
+		&models.Role{
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake db:migrate"},
+		},
+	}
+
+	continueOnError := mergeContinueOnError(roles)
+
+	if len(continueOnError) != 1 {
+		t.Errorf("wrong number of stages. want=%d, got=%d", 1, len(continueOnError))
+	}
+	if len(continueOnError[preDeployment]) != 1 || continueOnError[preDeployment][0] != "stop" {
+		t.Errorf("wrong prefixes for %s: %v", preDeployment, continueOnError[preDeployment])
+	}
+}
+
+func TestExecuteStageMaxFailedHosts(t *testing.T) {
+	s1 := newTestSSHServer(t)
+	defer s1.Close()
+	s2 := newTestSSHServer(t)
+	defer s2.Close()
+
+	tests := []struct {
+		tolerance      *models.FailureTolerance
+		expectedFailed []string
+		fails          bool
+	}{
+		{nil, []string{}, true},
+		{&models.FailureTolerance{Hosts: 1}, []string{s1.Addr()}, false},
+		{&models.FailureTolerance{Percent: 50}, []string{s1.Addr()}, false},
+		{&models.FailureTolerance{Percent: 49}, []string{}, true},
+	}
+
+	for _, tt := range tests {
+		failing := newTestWorker(t, s1)
+		failing.scripts = map[models.DeploymentStage]string{preDeployment: "fail", migrate: "migrate"}
+		passing := newTestWorker(t, s2)
+		passing.scripts = map[models.DeploymentStage]string{preDeployment: "echo", migrate: "migrate"}
+
+		testManager := &Manager{
+			logger:  NewDeploymentLogger(&models.Deployment{}, nil),
+			killed:  make(chan struct{}),
+			workers: []*Worker{failing, passing},
+			config: &models.DeploymentConfig{
+				MaxFailedHosts: map[models.DeploymentStage]*models.FailureTolerance{preDeployment: tt.tolerance},
+			},
+		}
+
+		err := testManager.executeStage(preDeployment)
+		if (err != nil) != tt.fails {
+			t.Errorf("tolerance=%+v: wrong result. want fail=%t, got=%v", tt.tolerance, tt.fails, err)
+		}
+
+		failed := testManager.FailedHosts()
+		if len(failed) != len(tt.expectedFailed) || (len(failed) == 1 && failed[0] != tt.expectedFailed[0]) {
+			t.Errorf("tolerance=%+v: wrong failed hosts. want=%v, got=%v", tt.tolerance, tt.expectedFailed, failed)
+		}
+
+		if !tt.fails {
+			results := testManager.executeWorkersStage(migrate, 0)
+			if len(results) != 2 || !results[0].skipped || results[1].skipped {
+				t.Errorf("expected the failed host to be skipped in the next stage, got=%+v", results)
+			}
+		}
+
+		failing.Close()
+		passing.Close()
+		drainCommands(s1, s2)
+	}
+}
+
+func drainCommands(servers ...*testSSHServer) {
+	for _, s := range servers {
+		for len(s.commands) > 0 {
+			<-s.commands
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	targetEnv := map[string]string{"RAILS_ENV": "production", "LOG_LEVEL": "info"}
+	roles := []*models.Role{
+		&models.Role{Env: map[string]string{"LOG_LEVEL": "debug"}},
+		&models.Role{Env: map[string]string{"QUEUE": "default"}},
+	}
+
+	env := mergeEnv(targetEnv, roles)
+
+	expected := map[string]string{"RAILS_ENV": "production", "LOG_LEVEL": "debug", "QUEUE": "default"}
+	if len(env) != len(expected) {
+		t.Errorf("wrong number of env variables. want=%d, got=%d", len(expected), len(env))
+	}
+	for name, value := range expected {
+		if env[name] != value {
+			t.Errorf("wrong value of %s. want=%s, got=%s", name, value, env[name])
+		}
+	}
+	if targetEnv["LOG_LEVEL"] != "info" {
+		t.Errorf("env of the target was modified")
+	}
+}
@@ -0,0 +1,116 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// redactor replaces sensitive parts of log messages with models.MaskedValue:
+// known values, like secrets, and the matches of regular expressions, like
+// passwords that scripts print.
+type redactor struct {
+	mu       sync.RWMutex
+	values   []string
+	known    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// addValues makes the redactor replace the values. Longer values are replaced
+// first, so a value that contains another one is masked completely. Values
+// that were added before, e.g. by the worker of another host, are skipped.
+func (r *redactor) addValues(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.known == nil {
+		r.known = map[string]bool{}
+	}
+
+	for _, v := range values {
+		if v != "" && !r.known[v] {
+			r.known[v] = true
+			r.values = append(r.values, v)
+		}
+	}
+	sort.Sort(byLengthDesc(r.values))
+}
+
+// addPatterns makes the redactor replace the matches of the patterns. If a
+// pattern has groups, only the groups are replaced, e.g. the password in
+// `password=(\S+)`.
+func (r *redactor) addPatterns(patterns ...*regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.patterns = append(r.patterns, patterns...)
+}
+
+func (r *redactor) redact(msg string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, v := range r.values {
+		msg = strings.Replace(msg, v, models.MaskedValue, -1)
+	}
+	for _, p := range r.patterns {
+		msg = redactMatches(p, msg)
+	}
+	return msg
+}
+
+func redactMatches(p *regexp.Regexp, msg string) string {
+	var b bytes.Buffer
+	last := 0
+
+	for _, match := range p.FindAllStringSubmatchIndex(msg, -1) {
+		spans := [][]int{match[:2]}
+		if len(match) > 2 {
+			spans = spans[:0]
+			for i := 2; i < len(match); i += 2 {
+				spans = append(spans, match[i:i+2])
+			}
+		}
+
+		for _, span := range spans {
+			start, end := span[0], span[1]
+			// Groups that didn't participate in the match, are empty or
+			// nested in an earlier group
+			if start < 0 || start == end || start < last {
+				continue
+			}
+			b.WriteString(msg[last:start])
+			b.WriteString(models.MaskedValue)
+			last = end
+		}
+	}
+
+	b.WriteString(msg[last:])
+	return b.String()
+}
+
+// compileRedactPatterns compiles the redact patterns of a target.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %s", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int           { return len(s) }
+func (s byLengthDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLengthDesc) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// scpUpload copies the local file to remotePath on the host with the scp
+// protocol, over a new session of the SSH connection. The upload is aborted
+// if the deadline is exceeded or the deployment is killed.
+func scpUpload(client *ssh.Client, localPath, remotePath string, killed <-chan struct{}, d deadline) error {
+	if !d.isZero() && !time.Now().Before(d.at) {
+		return d.err()
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- scpSend(session, f, info.Size(), remotePath)
+	}()
+
+	var timeout <-chan time.Time
+	if !d.isZero() {
+		timer := time.NewTimer(d.at.Sub(time.Now()))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// Closing the session makes the reads and writes of scpSend fail
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		session.Close()
+		<-done
+		return d.err()
+	case <-killed:
+		session.Close()
+		<-done
+		return ErrKilled
+	}
+}
+
+// scpSend starts the remote scp in the session and sends it the file.
+func scpSend(session *ssh.Session, f io.Reader, size int64, remotePath string) error {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	acks := bufio.NewReader(stdout)
+
+	err = session.Start("scp -qt " + shellQuote(path.Dir(remotePath)))
+	if err != nil {
+		return err
+	}
+
+	if err = readScpAck(acks); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdin, "C0644 %d %s\n", size, path.Base(remotePath))
+	if err = readScpAck(acks); err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(stdin, f); err != nil {
+		return err
+	}
+	fmt.Fprint(stdin, "\x00")
+	if err = readScpAck(acks); err != nil {
+		return err
+	}
+
+	stdin.Close()
+	return session.Wait()
+}
+
+// readScpAck reads the response of the remote scp, which is a 0 byte if
+// everything is fine, or 1 or 2 followed by an error message.
+func readScpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+
+	msg, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if msg == "" {
+		return errors.New("scp failed")
+	}
+	return fmt.Errorf("scp failed: %s", strings.TrimSpace(msg))
+}
+
+// shellQuote quotes s with single quotes for sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
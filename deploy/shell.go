@@ -0,0 +1,330 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The command a script in the shell script mode is piped to. The script is
+// read completely before it's executed, so its commands don't read the rest of
+// the script as their input.
+const shellCommand = `bash -e -c "$(cat)"`
+
+// executeShellScript runs the whole script in a single bash session, which
+// exits at the first failing command. The steps of the script, separated by
+// blank lines, are logged like the lines of a script in the default mode: a
+// marker is echoed before every step and picked out of the output again.
+func (w *Worker) executeShellScript(script string, d deadline) error {
+	if w.wasKilled() {
+		return ErrKilled
+	}
+
+	token, err := newMarkerToken()
+	if err != nil {
+		return err
+	}
+
+	steps := newStepTracker(w, splitSteps(script), token)
+	w.steps = steps
+	defer func() { w.steps = nil }()
+
+	err = w.runCommand(shellCommand, strings.NewReader(steps.script()), d)
+	steps.finish(err)
+
+	return err
+}
+
+// splitSteps splits the script into its steps, the blocks of lines that are
+// separated by blank lines. Blank lines inside of quotes, heredocs and compound
+// commands don't separate steps, since the markers echoed between the steps
+// would end up in them.
+func splitSteps(script string) []string {
+	steps := []string{}
+	lines := []string{}
+	state := &shellState{}
+
+	for _, line := range strings.Split(script, "\n") {
+		if strings.TrimSpace(line) == "" && state.topLevel() {
+			if len(lines) > 0 {
+				steps = append(steps, strings.Join(lines, "\n"))
+				lines = []string{}
+			}
+			continue
+		}
+		lines = append(lines, line)
+		state.feed(line)
+	}
+	if len(lines) > 0 {
+		steps = append(steps, strings.Join(lines, "\n"))
+	}
+
+	return steps
+}
+
+// Keywords that open and close compound commands, if they are the first word
+// of a command
+var (
+	shellOpeningKeywords = map[string]bool{"if": true, "case": true, "do": true, "{": true}
+	shellClosingKeywords = map[string]bool{"fi": true, "esac": true, "done": true, "}": true}
+	// Keywords after which the next word starts a command again
+	shellCommandKeywords = map[string]bool{"then": true, "else": true, "elif": true, "do": true, "if": true, "while": true, "until": true, "{": true, "!": true}
+)
+
+// shellState follows the lines of a script far enough to tell whether bash is
+// at the top level after them: outside of quotes, heredocs and compound
+// commands, and not in the middle of a command that is continued on the next
+// line.
+type shellState struct {
+	quote     byte
+	heredocs  []heredoc
+	depth     int
+	continued bool
+}
+
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+}
+
+func (s *shellState) topLevel() bool {
+	return s.quote == 0 && len(s.heredocs) == 0 && s.depth <= 0 && !s.continued
+}
+
+func (s *shellState) feed(line string) {
+	if s.quote == 0 && len(s.heredocs) > 0 {
+		h := s.heredocs[0]
+		body := line
+		if h.stripTabs {
+			body = strings.TrimLeft(body, "\t")
+		}
+		if body == h.delimiter {
+			s.heredocs = s.heredocs[1:]
+		}
+		return
+	}
+
+	word := []byte{}
+	commandStart := s.quote == 0 && !s.continued
+	endWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := string(word)
+		word = word[:0]
+		if !commandStart {
+			return
+		}
+		switch {
+		case shellOpeningKeywords[w]:
+			s.depth++
+		case shellClosingKeywords[w]:
+			s.depth--
+		}
+		commandStart = shellCommandKeywords[w]
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch s.quote {
+		case '\'':
+			if c == '\'' {
+				s.quote = 0
+			}
+			continue
+		case '"':
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				s.quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case c == '\\':
+			i++
+			word = append(word, 'x')
+		case c == '\'' || c == '"':
+			s.quote = c
+			word = append(word, 'x')
+		case c == '#' && len(word) == 0:
+			i = len(line)
+		case c == '<' && strings.HasPrefix(line[i:], "<<<"):
+			endWord()
+			i += 2
+		case c == '<' && strings.HasPrefix(line[i:], "<<"):
+			endWord()
+			h, n := parseHeredoc(line[i+2:])
+			if h.delimiter != "" {
+				s.heredocs = append(s.heredocs, h)
+			}
+			i += 1 + n
+		case c == ';' || c == '&' || c == '|' || c == '(' || c == ')':
+			endWord()
+			commandStart = true
+		case c == ' ' || c == '\t':
+			endWord()
+		default:
+			word = append(word, c)
+		}
+	}
+	endWord()
+
+	trimmed := strings.TrimRight(line, " \t")
+	s.continued = s.quote == 0 && (strings.HasSuffix(trimmed, "\\") ||
+		strings.HasSuffix(trimmed, "&&") || strings.HasSuffix(trimmed, "||") ||
+		strings.HasSuffix(trimmed, "|"))
+}
+
+// parseHeredoc parses the delimiter following a "<<" and returns the heredoc
+// and the number of bytes read.
+func parseHeredoc(s string) (heredoc, int) {
+	h := heredoc{}
+	i := 0
+
+	if i < len(s) && s[i] == '-' {
+		h.stripTabs = true
+		i++
+	}
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+
+	delimiter := []byte{}
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' || c == '"' || c == '\\' {
+			continue
+		}
+		if strings.IndexByte(" \t;&|<>()", c) >= 0 {
+			break
+		}
+		delimiter = append(delimiter, c)
+	}
+	h.delimiter = string(delimiter)
+
+	return h, i
+}
+
+func newMarkerToken() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// stepTracker logs the start, success and failure of the steps of a shell
+// script, based on the markers in its output.
+type stepTracker struct {
+	w      *Worker
+	steps  []string
+	prefix string
+
+	mu      sync.Mutex
+	current int
+	// Done once the output of the script is read completely
+	output sync.WaitGroup
+}
+
+func newStepTracker(w *Worker, steps []string, token string) *stepTracker {
+	return &stepTracker{
+		w:       w,
+		steps:   steps,
+		prefix:  fmt.Sprintf("__applikatoni_%s_step_", token),
+		current: -1,
+	}
+}
+
+// script returns the steps with a marker echoed before every step and after
+// the last one.
+func (t *stepTracker) script() string {
+	var b bytes.Buffer
+	for i, step := range t.steps {
+		fmt.Fprintf(&b, "echo %s%d\n%s\n", t.prefix, i, step)
+	}
+	fmt.Fprintf(&b, "echo %s%d\n", t.prefix, len(t.steps))
+	return b.String()
+}
+
+// filter returns a reader of the output with the markers removed. The markers
+// are handled as they are read.
+func (t *stepTracker) filter(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+
+	t.output.Add(1)
+	go func() {
+		defer t.output.Done()
+		defer pw.Close()
+
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if i := strings.Index(line, t.prefix); i >= 0 {
+				// A marker that follows output without a trailing newline
+				if i > 0 {
+					pw.Write([]byte(line[:i] + "\n"))
+				}
+				t.marker(strings.TrimSpace(line[i+len(t.prefix):]))
+			} else if line != "" {
+				pw.Write([]byte(line))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
+// marker logs the success of the current step and the start of the next one.
+func (t *stepTracker) marker(index string) {
+	next, err := strconv.Atoi(index)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current >= 0 {
+		t.w.logCommandSuccess(t.steps[t.current])
+	}
+	t.current = -1
+	if next < len(t.steps) {
+		t.current = next
+		t.w.logCommandStart(t.steps[next])
+	}
+}
+
+// finish logs the result of the step that was running when the script exited.
+func (t *stepTracker) finish(err error) {
+	t.output.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current < 0 {
+		if err != nil {
+			t.w.logCommandFail("bash", err)
+		}
+		return
+	}
+
+	if err != nil {
+		t.w.logCommandFail(t.steps[t.current], err)
+	} else {
+		t.w.logCommandSuccess(t.steps[t.current])
+	}
+	t.current = -1
+}
@@ -0,0 +1,187 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestSplitSteps(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected []string
+	}{
+		{"", []string{}},
+		{"cd /srv\nmake", []string{"cd /srv\nmake"}},
+		{"cd /srv\n\n\nmake\n  \nmake install\n", []string{"cd /srv", "make", "make install"}},
+		{"\nif true; then\n  echo yes\nfi\n", []string{"if true; then\n  echo yes\nfi"}},
+		{"cat > a <<EOF\none\n\ntwo\nEOF\n\nmake", []string{"cat > a <<EOF\none\n\ntwo\nEOF", "make"}},
+		{"cat > a <<-'END' && make\n\tone\n\n\tEND\n\nls", []string{"cat > a <<-'END' && make\n\tone\n\n\tEND", "ls"}},
+		{"echo 'one\n\ntwo'\n\nls", []string{"echo 'one\n\ntwo'", "ls"}},
+		{"echo \"a \\\" b\n\nc\"\n\nls", []string{"echo \"a \\\" b\n\nc\"", "ls"}},
+		{"if true; then\n  make\n\n  make install\nfi\n\nls", []string{"if true; then\n  make\n\n  make install\nfi", "ls"}},
+		{"for i in 1 2; do\n  echo $i\n\ndone\n\nls", []string{"for i in 1 2; do\n  echo $i\n\ndone", "ls"}},
+		{"make \\\n\n  install\n\nls", []string{"make \\\n\n  install", "ls"}},
+		{"echo if fi # done\n\nls", []string{"echo if fi # done", "ls"}},
+		{"cat <<< here\n\nls", []string{"cat <<< here", "ls"}},
+	}
+
+	for _, tt := range tests {
+		steps := splitSteps(tt.script)
+		if strings.Join(steps, "|") != strings.Join(tt.expected, "|") || len(steps) != len(tt.expected) {
+			t.Errorf("wrong steps for %q. want=%q, got=%q", tt.script, tt.expected, steps)
+		}
+	}
+}
+
+type testShellEntry struct {
+	entryType LogEntryType
+	message   string
+}
+
+func readShellEntries(w *Worker) []testShellEntry {
+	entries := []testShellEntry{}
+	for len(w.logger.ch) > 0 {
+		entry := <-w.logger.ch
+		entries = append(entries, testShellEntry{entry.EntryType, entry.Message})
+	}
+	return entries
+}
+
+func TestLocalWorkerExecuteShellScript(t *testing.T) {
+	w := newTestLocalWorker()
+	w.shellStages = map[models.DeploymentStage]bool{preDeployment: true}
+	w.scripts = map[models.DeploymentStage]string{
+		preDeployment: "cd /\nGREETING=hello\n\nif [ \"$GREETING\" = hello ]; then\n  pwd\nfi\ncat <<EOF\nheredoc\nEOF",
+	}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	expected := []testShellEntry{
+		{COMMAND_START, "cd /\nGREETING=hello"},
+		{COMMAND_SUCCESS, "cd /\nGREETING=hello"},
+		{COMMAND_START, "if [ \"$GREETING\" = hello ]; then\n  pwd\nfi\ncat <<EOF\nheredoc\nEOF"},
+		{COMMAND_STDOUT_OUTPUT, "/\n"},
+		{COMMAND_STDOUT_OUTPUT, "heredoc\n"},
+		{COMMAND_SUCCESS, "if [ \"$GREETING\" = hello ]; then\n  pwd\nfi\ncat <<EOF\nheredoc\nEOF"},
+	}
+
+	entries := readShellEntries(w)
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d (%+v)", len(expected), len(entries), entries)
+	}
+	for i, e := range expected {
+		if entries[i].entryType != e.entryType || !strings.Contains(entries[i].message, e.message) {
+			t.Errorf("wrong log entry %d. want=%+v, got=%+v", i, e, entries[i])
+		}
+	}
+}
+
+func TestLocalWorkerExecuteShellScriptHeredoc(t *testing.T) {
+	w := newTestLocalWorker()
+	w.shellStages = map[models.DeploymentStage]bool{preDeployment: true}
+	w.scripts = map[models.DeploymentStage]string{
+		preDeployment: "cat <<EOF\nfirst\n\nsecond\nEOF\n\necho done",
+	}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	output := ""
+	for _, e := range readShellEntries(w) {
+		if e.entryType == COMMAND_STDOUT_OUTPUT {
+			output += e.message
+		}
+	}
+	if output != "first\n\nsecond\ndone\n" {
+		t.Errorf("heredoc changed by the step markers. want=%q, got=%q", "first\n\nsecond\ndone\n", output)
+	}
+}
+
+func TestLocalWorkerExecuteShellScriptFailure(t *testing.T) {
+	w := newTestLocalWorker()
+	w.shellStages = map[models.DeploymentStage]bool{preDeployment: true}
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "true\n\nfalse\necho not\n\necho reached"}
+
+	result := w.Execute(preDeployment)
+	if result.err == nil {
+		t.Fatalf("expected the failing command to fail the stage")
+	}
+
+	entries := readShellEntries(w)
+	last := entries[len(entries)-1]
+	if last.entryType != COMMAND_FAIL || !strings.Contains(last.message, "false\necho not") {
+		t.Errorf("expected the second step to fail, got=%+v", last)
+	}
+	for _, e := range entries {
+		if e.entryType == COMMAND_STDOUT_OUTPUT {
+			t.Errorf("expected no output after the failed command, got=%q", e.message)
+		}
+	}
+}
+
+func TestWorkerExecuteShellScript(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.shellStages = map[models.DeploymentStage]bool{preDeployment: true}
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "cd /srv\nmake\n\nmake install"}
+
+	w.Execute(preDeployment)
+
+	if cmd := <-s.commands; cmd != shellCommand {
+		t.Errorf("wrong command. want=%q, got=%q", shellCommand, cmd)
+	}
+	if len(s.commands) != 0 {
+		t.Errorf("expected the script to run in a single session")
+	}
+}
+
+func TestMergeShellStages(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{
+			ScriptMode:      models.SCRIPT_MODE_SHELL,
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "make"},
+		},
+		&models.Role{
+			ScriptTemplates: map[models.DeploymentStage]string{migrate: "rake db:migrate"},
+		},
+	}
+
+	shellStages, err := mergeShellStages(roles)
+	if err != nil {
+		t.Fatalf("expected no error, got=%s", err)
+	}
+
+	if !shellStages[preDeployment] || shellStages[migrate] {
+		t.Errorf("wrong shell stages: %v", shellStages)
+	}
+}
+
+func TestMergeShellStagesMixedModes(t *testing.T) {
+	roles := []*models.Role{
+		&models.Role{
+			Name:            "web",
+			ScriptMode:      models.SCRIPT_MODE_SHELL,
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "make"},
+		},
+		&models.Role{
+			Name:            "workers",
+			ScriptTemplates: map[models.DeploymentStage]string{preDeployment: "stop"},
+		},
+	}
+
+	_, err := mergeShellStages(roles)
+	if err == nil {
+		t.Errorf("expected an error for roles with different script modes for one stage")
+	}
+}
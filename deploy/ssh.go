@@ -16,17 +16,32 @@ func newSSHClient(host string, sshConfig *ssh.ClientConfig) (*ssh.Client, error)
 	return client, nil
 }
 
-func newSSHClientConfig(user string, key []byte) (*ssh.ClientConfig, error) {
-	signer, err := ssh.ParsePrivateKey(key)
+// newSSHClientThrough connects to host over the connection of another client,
+// e.g. one connected to a jump host.
+func newSSHClientThrough(via *ssh.Client, host string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", host)
 	if err != nil {
+		log.Println("dialing through jump host failed", err)
 		return nil, err
 	}
 
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, sshConfig)
+	if err != nil {
+		conn.Close()
+		log.Println("ssh.NewClientConn failed", err)
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func newSSHClientConfig(user string, signer ssh.Signer, hostKeyCallback ssh.HostKeyCallback) *ssh.ClientConfig {
 	config := &ssh.ClientConfig{
 		User: user,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
+		HostKeyCallback: hostKeyCallback,
 	}
-	return config, nil
+	return config
 }
@@ -0,0 +1,203 @@
+package deploy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is a minimal SSH server for tests. Every command exits
+// successfully right away, except "fail", which exits with status 1, "hang",
+// which only returns once the session is closed, and "scp -qt <dir>", which receives a file and passes it on to
+// uploads, unless dir is /hang, in which case it never answers. Executed commands are passed on to commands, signals sent to a
+// session to signals. Env variables are only accepted if their name is in
+// acceptEnv, and then passed on to env as NAME=value.
+type testSSHServer struct {
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	commands  chan string
+	signals   chan ssh.Signal
+	uploads   chan testUpload
+	acceptEnv map[string]bool
+	env       chan string
+}
+
+type testUpload struct {
+	path    string
+	content string
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	hostKey, err := ssh.ParsePrivateKey(generatePrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSSHServer{
+		listener: listener,
+		config:   config,
+		commands: make(chan string, 10),
+		signals:  make(chan ssh.Signal, 10),
+		uploads:  make(chan testUpload, 10),
+		env:      make(chan string, 10),
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *testSSHServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+
+			for newChannel := range chans {
+				if newChannel.ChannelType() != "session" {
+					newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+					continue
+				}
+
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go s.handleSession(channel, requests)
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			req.Reply(true, nil)
+
+			// The payload is the command prefixed with its length
+			cmd := string(req.Payload[4:])
+			s.commands <- cmd
+			if cmd == "hang" {
+				continue
+			}
+			if strings.HasPrefix(cmd, "scp -qt ") {
+				go s.receiveFile(channel, strings.Trim(cmd[len("scp -qt "):], "'"))
+				continue
+			}
+
+			status := make([]byte, 4)
+			if cmd == "fail" {
+				binary.BigEndian.PutUint32(status, 1)
+			}
+			channel.SendRequest("exit-status", false, status)
+			channel.Close()
+		case "signal":
+			s.signals <- ssh.Signal(req.Payload[4:])
+		case "env":
+			var env struct{ Name, Value string }
+			ssh.Unmarshal(req.Payload, &env)
+			accepted := s.acceptEnv[env.Name]
+			if accepted {
+				s.env <- env.Name + "=" + env.Value
+			}
+			req.Reply(accepted, nil)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// receiveFile is the sink side of the scp protocol for a single file
+func (s *testSSHServer) receiveFile(channel ssh.Channel, dir string) {
+	defer channel.Close()
+
+	if dir == "/hang" {
+		io.Copy(ioutil.Discard, channel)
+		return
+	}
+
+	r := bufio.NewReader(channel)
+	channel.Write([]byte{0})
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	var mode string
+	var size int
+	var name string
+	_, err = fmt.Sscanf(header, "C%s %d %s\n", &mode, &size, &name)
+	if err != nil {
+		channel.Write([]byte("\x02invalid header\n"))
+		return
+	}
+	channel.Write([]byte{0})
+
+	content := make([]byte, size+1)
+	_, err = io.ReadFull(r, content)
+	if err != nil {
+		return
+	}
+	channel.Write([]byte{0})
+
+	s.uploads <- testUpload{path: path.Join(dir, name), content: string(content[:size])}
+
+	status := make([]byte, 4)
+	channel.SendRequest("exit-status", false, status)
+}
+
+func newTestWorker(t *testing.T, s *testSSHServer) *Worker {
+	config := &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	w := &Worker{
+		host:      &models.Host{Name: s.Addr()},
+		sshConfig: config,
+		logger:    NewDeploymentLogger(&models.Deployment{}, nil),
+	}
+
+	err := w.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
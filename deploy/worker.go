@@ -2,13 +2,17 @@ package deploy
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type ExecutionResult struct {
@@ -18,23 +22,130 @@ type ExecutionResult struct {
 	skipped   bool
 }
 
+// TimeoutError is returned if a command is aborted, because the timeout of its
+// stage or the deployment was exceeded.
+type TimeoutError struct {
+	Reason string
+}
+
+func (e *TimeoutError) Error() string {
+	return "command timed out: " + e.Reason
+}
+
+// deadline is the point in time at which the commands of a worker are
+// aborted. The zero value means no deadline.
+type deadline struct {
+	at     time.Time
+	reason string
+}
+
+func (d deadline) isZero() bool {
+	return d.at.IsZero()
+}
+
+func (d deadline) err() error {
+	return &TimeoutError{Reason: d.reason}
+}
+
 type Worker struct {
 	sshConfig *ssh.ClientConfig
 	sshClient *ssh.Client
+	jumpHosts *jumpHostPool
+	jumpChain []*models.JumpHost
+	sshAgent  agent.Agent // Forwarded to the host if set
 	host      *models.Host
 	logger    *DeploymentLogger
 	scripts   map[models.DeploymentStage]string // No ScriptTemplate here, we need the rendered one
+
+	// The rendered scripts with the secret options masked, safe to show
+	maskedScripts map[models.DeploymentStage]string
+
+	// The rendered health checks of the VERIFY stage, and the same health
+	// checks with the secret options masked
+	healthChecks       []*models.HealthCheck
+	maskedHealthChecks []*models.HealthCheck
+
+	// The stages that are executed on only one host of a role, mapped to
+	// the name of the role their script belongs to
+	runOnce map[models.DeploymentStage]string
+
+	// The prefixes of the commands whose failure doesn't stop the script of
+	// a stage
+	continueOnError map[models.DeploymentStage][]string
+
+	// The stages whose script is executed in a single bash session, see
+	// executeShellScript
+	shellStages map[models.DeploymentStage]bool
+	// Set while a script in a single bash session is running
+	steps *stepTracker
+
+	// The resolved env variables of the target and the roles of the host,
+	// set in the SSH session of every command, see setEnv
+	env map[string]string
+	// The names of the env variables the SSH server of the host rejected
+	rejectedEnv   map[string]bool
+	rejectedEnvMu sync.Mutex
+
+	// Set as soon as the worker executed a script of a stage on its host
+	touched bool
+	// Set by the Manager if the host failed a stage within the tolerance of
+	// max_failed_hosts. The host is skipped in the following stages.
+	failed bool
+
+	// The timeouts of the stages, taken from the roles of the host
+	stageTimeouts map[models.DeploymentStage]time.Duration
+	// Set by the Manager if the deployment has a timeout
+	deploymentDeadline deadline
+
+	// Closed by the Manager if the deployment is killed
+	killed <-chan struct{}
+	// How long a killed or timed out command has to exit after SIGTERM,
+	// before it is sent SIGKILL
+	gracePeriod time.Duration
 }
 
 func (w *Worker) Connect() error {
-	client, err := newSSHClient(w.host.Name, w.sshConfig)
+	if w.host.IsLocal() {
+		return nil
+	}
+
+	var client *ssh.Client
+	var err error
+
+	if len(w.jumpChain) == 0 {
+		client, err = newSSHClient(w.host.Name, w.sshConfig)
+	} else {
+		client, err = w.jumpHosts.Dial(w.jumpChain, w.host.Name, w.sshConfig)
+	}
 	if err != nil {
+		w.logger.LogCmdFail(w.host.Name, "ssh connect", err)
 		return err
 	}
+
+	if w.sshAgent != nil {
+		err = agent.ForwardToAgent(client, w.sshAgent)
+		if err != nil {
+			client.Close()
+			return err
+		}
+	}
 	w.sshClient = client
 	return nil
 }
 
+// Alive reports whether the SSH connection to the host is still usable. The
+// local host is always alive.
+func (w *Worker) Alive() bool {
+	if w.host.IsLocal() {
+		return true
+	}
+	if w.sshClient == nil {
+		return false
+	}
+	_, _, err := w.sshClient.SendRequest("keepalive@applikatoni", true, nil)
+	return err == nil
+}
+
 func (w *Worker) Close() error {
 	if w.sshClient != nil {
 		return w.sshClient.Close()
@@ -42,32 +153,89 @@ func (w *Worker) Close() error {
 	return nil
 }
 
+// HasScript reports whether the worker has something to execute in the
+// stage: a script or, in the VERIFY stage, health checks.
+func (w *Worker) HasScript(stage models.DeploymentStage) bool {
+	_, present := w.scripts[stage]
+	return present || w.hasHealthChecks(stage)
+}
+
+func (w *Worker) hasHealthChecks(stage models.DeploymentStage) bool {
+	return stage == models.STAGE_VERIFY && len(w.healthChecks) > 0
+}
+
+// Execute runs the script of the stage on the host. In the VERIFY stage the
+// health checks are run after the script, if there is one.
 func (w *Worker) Execute(stage models.DeploymentStage) ExecutionResult {
-	script, present := w.scripts[stage]
-	if !present {
+	if !w.HasScript(stage) {
 		return ExecutionResult{origin: w.host.Name, skipped: true}
 	}
 
 	start := time.Now()
-	err := w.executeScript(script)
+	d := w.deadline(stage)
+
+	var err error
+	if script, present := w.scripts[stage]; present {
+		w.touched = true
+		if w.shellStages[stage] {
+			err = w.executeShellScript(script, d)
+		} else {
+			err = w.executeScript(script, d, w.continueOnError[stage])
+		}
+	}
+	if err == nil && w.hasHealthChecks(stage) {
+		err = w.verify(d)
+	}
 	timeTaken := time.Since(start)
 
 	return ExecutionResult{origin: w.host.Name, err: err, timeTaken: timeTaken}
 }
 
-func (w *Worker) executeScript(script string) error {
+// deadline returns the earlier one of the stage's and the deployment's
+// deadline. Rollbacks are not bound to the deployment's deadline, since they
+// have to run after a deployment timed out.
+func (w *Worker) deadline(stage models.DeploymentStage) deadline {
+	d := w.deploymentDeadline
+	if stage == models.STAGE_ROLLBACK {
+		d = deadline{}
+	}
+
+	if timeout := w.stageTimeouts[stage]; timeout > 0 {
+		stageDeadline := deadline{
+			at:     time.Now().Add(timeout),
+			reason: fmt.Sprintf("stage timeout of %s exceeded", timeout),
+		}
+		if d.isZero() || stageDeadline.at.Before(d.at) {
+			d = stageDeadline
+		}
+	}
+
+	return d
+}
+
+// executeScript runs the script line by line and stops at the first command
+// that fails, unless it starts with one of the continueOnError prefixes.
+func (w *Worker) executeScript(script string, d deadline, continueOnError []string) error {
 	r := strings.NewReader(script)
 	scanner := bufio.NewScanner(r)
 
 	for scanner.Scan() {
 		line := scanner.Text()
 
+		if w.wasKilled() {
+			return ErrKilled
+		}
+
 		w.logCommandStart(line)
 
-		err := w.runCommand(line)
+		err := w.runCommand(line, nil, d)
 		if err != nil {
 			w.logCommandFail(line, err)
-			return err
+			if err == ErrKilled || isTimeout(err) || !matchesPrefix(line, continueOnError) {
+				return err
+			}
+			w.logger.LogStageResult(fmt.Sprintf("%s - continuing after failed command %q", w.host.Name, line))
+			continue
 		}
 		w.logCommandSuccess(line)
 	}
@@ -80,7 +248,17 @@ func (w *Worker) executeScript(script string) error {
 	return nil
 }
 
-func (w *Worker) runCommand(cmd string) error {
+// runCommand runs the command on the host. If stdin is not nil, it's piped to
+// the command.
+func (w *Worker) runCommand(cmd string, stdin io.Reader, d deadline) error {
+	if !d.isZero() && !time.Now().Before(d.at) {
+		return d.err()
+	}
+
+	if w.host.IsLocal() {
+		return w.runLocalCommand(cmd, stdin, d)
+	}
+
 	session, err := w.sshClient.NewSession()
 	if err != nil {
 		log.Println("could not create new SSH session", err)
@@ -88,6 +266,14 @@ func (w *Worker) runCommand(cmd string) error {
 	}
 	defer session.Close()
 
+	if w.sshAgent != nil {
+		err = agent.RequestAgentForwarding(session)
+		if err != nil {
+			log.Println("could not request agent forwarding", err)
+			return err
+		}
+	}
+
 	sessionStderr, err := session.StderrPipe()
 	if err != nil {
 		log.Println("could not create new stderr pipe")
@@ -100,14 +286,151 @@ func (w *Worker) runCommand(cmd string) error {
 		log.Println("could not create new stdout pipe")
 		return err
 	}
-	go w.logOutput(COMMAND_STDOUT_OUTPUT, sessionStdout)
+	go w.logOutput(COMMAND_STDOUT_OUTPUT, w.stdout(sessionStdout))
 
-	if err = session.Start(cmd); err != nil {
+	session.Stdin = stdin
+	if err = session.Start(envPrelude(w.setEnv(session)) + cmd); err != nil {
 		log.Println("Start failed")
 		return err
 	}
 
-	return session.Wait()
+	return w.wait(session, d)
+}
+
+// wait waits for the command of the session to finish. If the deadline is
+// exceeded or the deployment is killed first, the command is terminated.
+func (w *Worker) wait(session *ssh.Session, d deadline) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if !d.isZero() {
+		timer := time.NewTimer(d.at.Sub(time.Now()))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		w.terminate(session, done)
+		return d.err()
+	case <-w.killed:
+		w.terminate(session, done)
+		return ErrKilled
+	}
+}
+
+// terminate sends SIGTERM to the remote process and SIGKILL if it didn't exit
+// within the grace period, before closing the session.
+func (w *Worker) terminate(session *ssh.Session, done <-chan error) {
+	err := session.Signal(ssh.SIGTERM)
+	if err != nil {
+		log.Println("could not send SIGTERM", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(w.gracePeriod):
+		err := session.Signal(ssh.SIGKILL)
+		if err != nil {
+			log.Println("could not send SIGKILL", err)
+		}
+	}
+
+	session.Close()
+}
+
+func (w *Worker) verify(d deadline) error {
+	checker := &healthChecker{
+		origin:    w.host.Name,
+		sshClient: w.sshClient,
+		logger:    w.logger,
+		killed:    w.killed,
+	}
+
+	return checker.run(w.healthChecks, w.maskedHealthChecks, d)
+}
+
+func matchesPrefix(cmd string, prefixes []string) bool {
+	cmd = strings.TrimSpace(cmd)
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Worker) wasKilled() bool {
+	select {
+	case <-w.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+// setEnv sets the env variables in the session, if the SSH server of the host
+// accepts them (AcceptEnv in its sshd_config), and returns the ones it
+// rejected. Variables rejected once are not sent again.
+func (w *Worker) setEnv(session *ssh.Session) map[string]string {
+	w.rejectedEnvMu.Lock()
+	defer w.rejectedEnvMu.Unlock()
+
+	rejected := map[string]string{}
+	for name, value := range w.env {
+		if w.rejectedEnv[name] {
+			rejected[name] = value
+			continue
+		}
+
+		err := session.Setenv(name, value)
+		if err != nil {
+			if w.rejectedEnv == nil {
+				w.rejectedEnv = map[string]bool{}
+			}
+			w.rejectedEnv[name] = true
+			rejected[name] = value
+		}
+	}
+
+	return rejected
+}
+
+// envPrelude returns the export of the env variables that precedes a command
+// on a remote host, for the variables the SSH server didn't accept. Unlike
+// the variables set in the session, these are part of the command line and
+// can be seen in the process list of the host.
+func envPrelude(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+
+	names := []string{}
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := []string{}
+	for _, name := range names {
+		vars = append(vars, name+"="+shellQuote(env[name]))
+	}
+
+	return "export " + strings.Join(vars, " ") + "; "
+}
+
+// stdout returns the reader of the output of a command, with the markers of
+// the steps removed if a script runs in a single bash session.
+func (w *Worker) stdout(r io.Reader) io.Reader {
+	if w.steps == nil {
+		return r
+	}
+	return w.steps.filter(r)
 }
 
 func (w *Worker) logOutput(entryType LogEntryType, r io.Reader) {
@@ -0,0 +1,215 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWorkerExecuteStageTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "echo\nhang\necho"}
+	w.stageTimeouts = map[models.DeploymentStage]time.Duration{preDeployment: 50 * time.Millisecond}
+
+	result := w.Execute(preDeployment)
+
+	if _, ok := result.err.(*TimeoutError); !ok {
+		t.Fatalf("expected a TimeoutError, got=%v", result.err)
+	}
+
+	select {
+	case sig := <-s.signals:
+		if sig != ssh.SIGTERM {
+			t.Errorf("wrong signal sent. want=%s, got=%s", ssh.SIGTERM, sig)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no signal sent to the timed out command")
+	}
+}
+
+func TestWorkerExecuteWithinTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "echo\necho"}
+	w.stageTimeouts = map[models.DeploymentStage]time.Duration{preDeployment: time.Minute}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Errorf("expected no error, got=%s", result.err)
+	}
+}
+
+func TestWorkerExecuteContinueOnError(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "fail\necho"}
+
+	result := w.Execute(preDeployment)
+	if result.err == nil {
+		t.Fatalf("expected the failed command to fail the script")
+	}
+	if cmd := <-s.commands; cmd != "fail" {
+		t.Errorf("wrong command. want=%q, got=%q", "fail", cmd)
+	}
+	if len(s.commands) != 0 {
+		t.Errorf("expected the script to stop after the failed command")
+	}
+
+	w.continueOnError = map[models.DeploymentStage][]string{preDeployment: []string{"fail"}}
+
+	result = w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+	for _, expected := range []string{"fail", "echo"} {
+		if cmd := <-s.commands; cmd != expected {
+			t.Errorf("wrong command. want=%q, got=%q", expected, cmd)
+		}
+	}
+}
+
+func TestWorkerDeadline(t *testing.T) {
+	deploymentDeadline := deadline{at: time.Now().Add(time.Hour), reason: "deployment"}
+
+	w := &Worker{
+		deploymentDeadline: deploymentDeadline,
+		stageTimeouts: map[models.DeploymentStage]time.Duration{
+			preDeployment:         time.Minute,
+			migrate:               2 * time.Hour,
+			models.STAGE_ROLLBACK: time.Minute,
+		},
+	}
+
+	tests := []struct {
+		stage          models.DeploymentStage
+		expectedReason string
+	}{
+		{preDeployment, "stage timeout of 1m0s exceeded"},
+		{migrate, "deployment"},
+		{"NO_TIMEOUT", "deployment"},
+		{models.STAGE_ROLLBACK, "stage timeout of 1m0s exceeded"},
+	}
+
+	for _, tt := range tests {
+		d := w.deadline(tt.stage)
+		if d.reason != tt.expectedReason {
+			t.Errorf("wrong deadline for %s. want=%q, got=%q", tt.stage, tt.expectedReason, d.reason)
+		}
+	}
+
+	w.stageTimeouts = nil
+	if d := w.deadline(models.STAGE_ROLLBACK); !d.isZero() {
+		t.Errorf("expected no deadline for rollback, got=%+v", d)
+	}
+}
+
+func TestWorkerExecuteKilled(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	killed := make(chan struct{})
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "hang\necho"}
+	w.killed = killed
+	w.gracePeriod = 10 * time.Millisecond
+
+	go func() {
+		<-s.commands
+		close(killed)
+	}()
+
+	result := w.Execute(preDeployment)
+	if result.err != ErrKilled {
+		t.Fatalf("wrong error. want=%s, got=%v", ErrKilled, result.err)
+	}
+
+	for _, expected := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+		select {
+		case sig := <-s.signals:
+			if sig != expected {
+				t.Errorf("wrong signal sent. want=%s, got=%s", expected, sig)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("signal %s not sent to the killed command", expected)
+		}
+	}
+
+	select {
+	case cmd := <-s.commands:
+		t.Errorf("command executed after kill: %s", cmd)
+	default:
+	}
+}
+
+func TestWorkerExecuteEnvPrelude(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.env = map[string]string{"RAILS_ENV": "production", "PASSWORD": "it's s3cr3t"}
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "rake db:migrate"}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	expected := `export PASSWORD='it'\''s s3cr3t' RAILS_ENV='production'; rake db:migrate`
+	if cmd := <-s.commands; cmd != expected {
+		t.Errorf("wrong command. want=%q, got=%q", expected, cmd)
+	}
+}
+
+func TestWorkerExecuteSessionEnv(t *testing.T) {
+	s := newTestSSHServer(t)
+	defer s.Close()
+	s.acceptEnv = map[string]bool{"PASSWORD": true}
+
+	w := newTestWorker(t, s)
+	defer w.Close()
+
+	w.env = map[string]string{"RAILS_ENV": "production", "PASSWORD": "it's s3cr3t"}
+	w.scripts = map[models.DeploymentStage]string{preDeployment: "rake db:migrate\nrake assets:precompile"}
+
+	result := w.Execute(preDeployment)
+	if result.err != nil {
+		t.Fatalf("expected no error, got=%s", result.err)
+	}
+
+	expected := []string{
+		`export RAILS_ENV='production'; rake db:migrate`,
+		`export RAILS_ENV='production'; rake assets:precompile`,
+	}
+	for _, e := range expected {
+		if cmd := <-s.commands; cmd != e {
+			t.Errorf("wrong command. want=%q, got=%q", e, cmd)
+		}
+	}
+
+	if len(s.env) != 2 {
+		t.Fatalf("wrong number of env variables set in the sessions. want=%d, got=%d", 2, len(s.env))
+	}
+	if env := <-s.env; env != "PASSWORD=it's s3cr3t" {
+		t.Errorf("wrong env variable set in the session. want=%q, got=%q", "PASSWORD=it's s3cr3t", env)
+	}
+}
@@ -3,15 +3,17 @@ package models
 import "fmt"
 
 type Application struct {
-	Name                 string    `json:"name"`
-	Targets              []*Target `json:"targets"`
-	ReadUsernames        []string  `json:"read_usernames"`
-	GitHubOwner          string    `json:"github_owner"`
-	GitHubRepo           string    `json:"github_repo"`
-	GitHubBranches       []string  `json:"github_branches"`
-	TravisImageURL       string    `json:"travis_image_url"`
-	DailyDigestReceivers []string  `json:"daily_digest_receivers"`
-	DailyDigestTarget    string    `json:"daily_digest_target"`
+	Name                 string           `json:"name"`
+	Targets              []*Target        `json:"targets"`
+	ReadUsernames        []string         `json:"read_usernames"`
+	GitHubOwner          string           `json:"github_owner"`
+	GitHubRepo           string           `json:"github_repo"`
+	GitHubBranches       []string         `json:"github_branches"`
+	TravisImageURL       string           `json:"travis_image_url"`
+	DailyDigestReceivers []string         `json:"daily_digest_receivers"`
+	DailyDigestTarget    string           `json:"daily_digest_target"`
+	Pipeline             []*PipelineStage `json:"pipeline"`
+	GitHubWebhookSecret  string           `json:"github_webhook_secret"`
 }
 
 func (a *Application) IsReader(userName string) bool {
@@ -11,3 +11,31 @@ func TestRepositoryURL(t *testing.T) {
 		t.Errorf("wrong repository URL. want=%s, got=%s", expected, got)
 	}
 }
+
+func TestNextPipelineStage(t *testing.T) {
+	a := &Application{
+		Pipeline: []*PipelineStage{
+			{Target: "staging"},
+			{Target: "production", AutoPromote: true},
+		},
+	}
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"staging", "production"},
+		{"production", ""},
+		{"sandbox", ""},
+	}
+
+	for _, tt := range tests {
+		var got string
+		if stage := a.NextPipelineStage(tt.target); stage != nil {
+			got = stage.Target
+		}
+		if got != tt.expected {
+			t.Errorf("wrong next stage for %s. want=%q, got=%q", tt.target, tt.expected, got)
+		}
+	}
+}
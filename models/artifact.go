@@ -0,0 +1,37 @@
+package models
+
+import (
+	"fmt"
+	"path"
+)
+
+const defaultArtifactRemoteDir = "/tmp"
+
+// Artifact is a tarball that is built or downloaded once per deployment on the
+// Applikatoni host and uploaded to every host before the first stage.
+type Artifact struct {
+	// Executed on the Applikatoni host, has to write the artifact to the file
+	// given as ArtifactFile. Rendered with the script options.
+	BuildScript string `json:"build_script"`
+	// Downloaded if there is no BuildScript. Rendered with the script options.
+	URL string `json:"url"`
+	// The directory on the hosts the artifact is uploaded to, defaults to /tmp
+	RemoteDir string `json:"remote_dir"`
+}
+
+// Path returns the path of the artifact of the commit on the hosts.
+func (a *Artifact) Path(commitSha string) string {
+	dir := a.RemoteDir
+	if dir == "" {
+		dir = defaultArtifactRemoteDir
+	}
+	return path.Join(dir, fmt.Sprintf("applikatoni-%s.tar.gz", commitSha))
+}
+
+func (a *Artifact) RenderBuildScript(options map[string]string) (string, error) {
+	return renderTemplate("build_script", a.BuildScript, options)
+}
+
+func (a *Artifact) RenderURL(options map[string]string) (string, error) {
+	return renderTemplate("url", a.URL, options)
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestArtifactScriptOptions(t *testing.T) {
+	dc := &DeploymentConfig{
+		Deployment:        &Deployment{CommitSha: "f00"},
+		PreviousCommitSha: "b4r",
+	}
+
+	if _, ok := dc.ScriptOptions()["ArtifactPath"]; ok {
+		t.Errorf("expected no ArtifactPath without artifact")
+	}
+
+	tests := []struct {
+		artifact         *Artifact
+		expected         string
+		expectedRollback string
+	}{
+		{&Artifact{}, "/tmp/applikatoni-f00.tar.gz", "/tmp/applikatoni-b4r.tar.gz"},
+		{&Artifact{RemoteDir: "/var/artifacts/"}, "/var/artifacts/applikatoni-f00.tar.gz", "/var/artifacts/applikatoni-b4r.tar.gz"},
+	}
+
+	for i, tt := range tests {
+		dc.Artifact = tt.artifact
+
+		if path := dc.ScriptOptions()["ArtifactPath"]; path != tt.expected {
+			t.Errorf("%d: wrong path. want=%q, got=%q", i, tt.expected, path)
+		}
+		if path := dc.RollbackScriptOptions()["ArtifactPath"]; path != tt.expectedRollback {
+			t.Errorf("%d: wrong rollback path. want=%q, got=%q", i, tt.expectedRollback, path)
+		}
+	}
+}
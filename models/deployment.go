@@ -5,10 +5,20 @@ import "time"
 type DeploymentState string
 
 const (
-	DEPLOYMENT_NEW        DeploymentState = "new"
-	DEPLOYMENT_ACTIVE     DeploymentState = "active"
-	DEPLOYMENT_SUCCESSFUL DeploymentState = "successful"
-	DEPLOYMENT_FAILED     DeploymentState = "failed"
+	DEPLOYMENT_NEW              DeploymentState = "new"
+	DEPLOYMENT_ACTIVE           DeploymentState = "active"
+	DEPLOYMENT_SUCCESSFUL       DeploymentState = "successful"
+	DEPLOYMENT_FAILED           DeploymentState = "failed"
+	DEPLOYMENT_ROLLED_BACK      DeploymentState = "rolled_back"
+	DEPLOYMENT_KILLED           DeploymentState = "killed"
+	DEPLOYMENT_QUEUED           DeploymentState = "queued"
+	DEPLOYMENT_CANCELLED        DeploymentState = "cancelled"
+	DEPLOYMENT_PENDING_APPROVAL DeploymentState = "pending_approval"
+	DEPLOYMENT_REJECTED         DeploymentState = "rejected"
+	DEPLOYMENT_SCHEDULED        DeploymentState = "scheduled"
+	// The deployment finished, but some hosts failed within the tolerance
+	// of max_failed_hosts
+	DEPLOYMENT_PARTIALLY_SUCCESSFUL DeploymentState = "partially_successful"
 )
 
 type Deployment struct {
@@ -22,4 +32,46 @@ type Deployment struct {
 	User            *User
 	ApplicationName string
 	TargetName      string
+	Stages          []DeploymentStage
+	KilledBy        string
+	// ScheduledAt is the time a scheduled deployment is due, nil if the
+	// deployment was not scheduled
+	ScheduledAt *time.Time
+	// PromotedFrom is the id of the deployment to the previous pipeline
+	// stage this deployment was promoted from, 0 if it wasn't promoted
+	PromotedFrom int
+	// FreezeOverride is the reason of the freeze that was overridden to
+	// create the deployment
+	FreezeOverride string
+	// StatusChecksOverride lists the required status checks that weren't
+	// passing when the deployment was created by overriding them
+	StatusChecksOverride string
+	// FailedHosts are the hosts that failed a stage of a partially
+	// successful deployment
+	FailedHosts []string
+	// QueuePosition is the 1-based position of a queued deployment in the
+	// queue of its target. It is not persisted.
+	QueuePosition int
+}
+
+// Approval is the decision of an approver to approve or reject a deployment
+// that is pending approval.
+type Approval struct {
+	Id           int
+	DeploymentId int
+	UserId       int
+	User         *User
+	Approved     bool
+	Comment      string
+	CreatedAt    time.Time
+}
+
+// DeploymentScript is the rendered script of one stage on one host of a
+// deployment, with the secret options of the roles masked.
+type DeploymentScript struct {
+	Id           int
+	DeploymentId int
+	Host         string
+	Stage        DeploymentStage
+	Script       string
 }
@@ -6,29 +6,115 @@ const assetsTimestampLayout string = "200601021504.05"
 
 func NewDeploymentConfig(d *Deployment, t *Target, stages []DeploymentStage) *DeploymentConfig {
 	return &DeploymentConfig{
-		User:       t.DeploymentUser,
-		SshKey:     []byte(t.DeploymentSshKey),
-		Stages:     stages,
-		Hosts:      t.Hosts,
-		Roles:      t.Roles,
-		StartTime:  time.Now(),
-		Deployment: d,
+		User:   t.DeploymentUser,
+		SshKey: []byte(t.DeploymentSshKey),
+		SshAuth: SshAuth{
+			KeyFile:         t.DeploymentSshKeyFile,
+			PassphraseEnv:   t.DeploymentSshKeyPassphraseEnv,
+			PassphraseFile:  t.DeploymentSshKeyPassphraseFile,
+			Certificate:     t.DeploymentSshCertificate,
+			CertificateFile: t.DeploymentSshCertificateFile,
+			ForwardAgent:    t.ForwardSshAgent,
+		},
+		Stages:          stages,
+		Hosts:           t.Hosts,
+		Roles:           t.Roles,
+		Rollout:         t.Rollout,
+		HostKeys:        t.HostKeys,
+		KnownHostsFile:  t.KnownHostsFile,
+		HostKeyMode:     t.HostKeyMode,
+		JumpHosts:       t.JumpHosts,
+		HealthChecks:    t.HealthChecks,
+		Artifact:        t.Artifact,
+		MaxFailedHosts:  t.MaxFailedHosts,
+		Env:             t.Env,
+		RedactPatterns:  t.RedactPatterns,
+		Timeout:         time.Duration(t.DeploymentTimeoutSeconds) * time.Second,
+		KillGracePeriod: t.KillGracePeriod(),
+		StartTime:       time.Now(),
+		Deployment:      d,
 	}
 }
 
 type DeploymentConfig struct {
 	User       string
 	SshKey     []byte
+	SshAuth    SshAuth
 	Stages     []DeploymentStage
 	Hosts      []*Host
 	Roles      []*Role
+	Rollout    *RolloutStrategy
 	StartTime  time.Time
 	Deployment *Deployment
+
+	HostKeys       []string
+	KnownHostsFile string
+	HostKeyMode    HostKeyMode
+	JumpHosts      []*JumpHost
+
+	// Checked once, directly from Applikatoni, in the VERIFY stage
+	HealthChecks []*HealthCheck
+	// If set, the artifact is uploaded to the hosts before the first stage
+	Artifact *Artifact
+	// How many hosts may fail a stage before the deployment fails
+	MaxFailedHosts map[DeploymentStage]*FailureTolerance
+	// Environment variables of the scripts on all hosts, overridden by the
+	// env of the roles
+	Env map[string]string
+	// Resolves the references to stored secrets in Env, nil if there are no
+	// stored secrets
+	Secrets SecretResolver
+	// Regular expressions whose matches are masked in the deployment log
+	RedactPatterns []string
+
+	// The deployment is aborted after this duration, if it is not 0
+	Timeout time.Duration
+	// Time a killed or timed out command has to exit before it's sent SIGKILL
+	KillGracePeriod time.Duration
+
+	// The CommitSha of the last successful deployment to the target. Empty
+	// if there is none, in which case no rollback is possible.
+	PreviousCommitSha string
+}
+
+// SshAuth holds the optional ways of the deployment user to authenticate
+// besides the inline SshKey
+type SshAuth struct {
+	// Path to the private key, used if no SshKey is set
+	KeyFile string
+	// Name of an environment variable or path to a file that contains the
+	// passphrase of an encrypted private key
+	PassphraseEnv  string
+	PassphraseFile string
+	// An SSH certificate for the private key, in the authorized_keys format
+	Certificate     string
+	CertificateFile string
+	// Forward the local ssh-agent (SSH_AUTH_SOCK) to the hosts
+	ForwardAgent bool
 }
 
 func (dc *DeploymentConfig) ScriptOptions() map[string]string {
-	return map[string]string{
-		"CommitSha":       dc.Deployment.CommitSha,
-		"AssetsTimestamp": dc.StartTime.UTC().Format(assetsTimestampLayout),
+	options := map[string]string{
+		"CommitSha":         dc.Deployment.CommitSha,
+		"PreviousCommitSha": dc.PreviousCommitSha,
+		"AssetsTimestamp":   dc.StartTime.UTC().Format(assetsTimestampLayout),
+	}
+	if dc.Artifact != nil {
+		options["ArtifactPath"] = dc.Artifact.Path(dc.Deployment.CommitSha)
+	}
+	return options
+}
+
+// RollbackScriptOptions returns the options used to render the ROLLBACK
+// scripts. In these, CommitSha is the commit to go back to and FailedCommitSha
+// the commit of the failed deployment. ArtifactPath is the path of the
+// artifact of the commit to go back to.
+func (dc *DeploymentConfig) RollbackScriptOptions() map[string]string {
+	options := dc.ScriptOptions()
+	options["CommitSha"] = dc.PreviousCommitSha
+	options["FailedCommitSha"] = dc.Deployment.CommitSha
+	if dc.Artifact != nil {
+		options["ArtifactPath"] = dc.Artifact.Path(dc.PreviousCommitSha)
 	}
+	return options
 }
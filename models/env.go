@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A value of env with this prefix is read from the environment variable of the
+// Applikatoni process named after the prefix, e.g. "env://DATABASE_PASSWORD".
+const ENV_REFERENCE_PREFIX = "env://"
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ResolveEnv returns a copy of the env with the references to environment
+// variables and stored secrets replaced by their values. It also returns the
+// values of the references, which must never be shown.
+func ResolveEnv(env map[string]string, secrets SecretResolver) (map[string]string, []string, error) {
+	resolved := map[string]string{}
+	hidden := []string{}
+
+	for name, value := range env {
+		if !envNamePattern.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid name of env variable %q", name)
+		}
+
+		if IsSecretReference(value) {
+			secret, err := ResolveSecret(value, secrets)
+			if err != nil {
+				return nil, nil, err
+			}
+			resolved[name] = secret
+			hidden = append(hidden, secret)
+			continue
+		}
+
+		if !strings.HasPrefix(value, ENV_REFERENCE_PREFIX) {
+			resolved[name] = value
+			continue
+		}
+
+		varName := strings.TrimPrefix(value, ENV_REFERENCE_PREFIX)
+		varValue, ok := os.LookupEnv(varName)
+		if !ok {
+			return nil, nil, fmt.Errorf("environment variable %s of env variable %s is not set", varName, name)
+		}
+		resolved[name] = varValue
+		hidden = append(hidden, varValue)
+	}
+
+	return resolved, hidden, nil
+}
+
+// MaskEnv returns a copy of the env with all values replaced by MaskedValue.
+func MaskEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+
+	masked := map[string]string{}
+	for name := range env {
+		masked[name] = MaskedValue
+	}
+	return masked
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("APPLIKATONI_TEST_PASSWORD", "s3cr3t")
+	defer os.Unsetenv("APPLIKATONI_TEST_PASSWORD")
+
+	env := map[string]string{
+		"RAILS_ENV":         "production",
+		"DATABASE_PASSWORD": "env://APPLIKATONI_TEST_PASSWORD",
+	}
+
+	resolved, secrets, err := ResolveEnv(env, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved["RAILS_ENV"] != "production" {
+		t.Errorf("wrong value. want=%s, got=%s", "production", resolved["RAILS_ENV"])
+	}
+	if resolved["DATABASE_PASSWORD"] != "s3cr3t" {
+		t.Errorf("wrong value. want=%s, got=%s", "s3cr3t", resolved["DATABASE_PASSWORD"])
+	}
+	if len(secrets) != 1 || secrets[0] != "s3cr3t" {
+		t.Errorf("wrong secrets. want=%v, got=%v", []string{"s3cr3t"}, secrets)
+	}
+}
+
+func TestResolveEnvErrors(t *testing.T) {
+	os.Unsetenv("APPLIKATONI_TEST_MISSING")
+
+	tests := []map[string]string{
+		{"PASSWORD": "env://APPLIKATONI_TEST_MISSING"},
+		{"INVALID-NAME": "value"},
+		{"FOO; rm -rf /": "value"},
+	}
+
+	for _, env := range tests {
+		_, _, err := ResolveEnv(env, nil)
+		if err == nil {
+			t.Errorf("expected error for env %v", env)
+		}
+	}
+}
+
+func TestResolveEnvSecrets(t *testing.T) {
+	secrets := func(name string) (string, error) {
+		if name != "db-password" {
+			return "", fmt.Errorf("secret %s not found", name)
+		}
+		return "s3cr3t", nil
+	}
+
+	resolved, hidden, err := ResolveEnv(map[string]string{"PASSWORD": "secret://db-password"}, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved["PASSWORD"] != "s3cr3t" {
+		t.Errorf("wrong value. want=%s, got=%s", "s3cr3t", resolved["PASSWORD"])
+	}
+	if len(hidden) != 1 || hidden[0] != "s3cr3t" {
+		t.Errorf("wrong hidden values. want=%v, got=%v", []string{"s3cr3t"}, hidden)
+	}
+
+	_, _, err = ResolveEnv(map[string]string{"PASSWORD": "secret://missing"}, secrets)
+	if err == nil {
+		t.Errorf("expected error for missing secret")
+	}
+
+	_, _, err = ResolveEnv(map[string]string{"PASSWORD": "secret://db-password"}, nil)
+	if err == nil {
+		t.Errorf("expected error without secrets")
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+// FailureTolerance is the number of hosts that may fail a stage without
+// failing the deployment. The hosts that failed are skipped in the following
+// stages and the deployment finishes as partially successful.
+type FailureTolerance struct {
+	Hosts   int `json:"hosts"`
+	Percent int `json:"percent"`
+}
+
+// MaxFailedHosts returns how many of the given number of hosts may fail. If
+// Hosts is set, Percent is ignored. At least one host has to succeed, so a
+// stage that fails on all hosts always fails the deployment.
+func (ft *FailureTolerance) MaxFailedHosts(hostCount int) int {
+	if ft == nil || hostCount < 1 {
+		return 0
+	}
+
+	max := 0
+	if ft.Hosts > 0 {
+		max = ft.Hosts
+	} else if ft.Percent > 0 {
+		// Round down, so that 25% of 3 hosts doesn't tolerate any failure
+		max = hostCount * ft.Percent / 100
+	}
+
+	if max > hostCount-1 {
+		return hostCount - 1
+	}
+	return max
+}
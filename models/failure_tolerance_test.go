@@ -0,0 +1,33 @@
+package models
+
+import "testing"
+
+func TestMaxFailedHosts(t *testing.T) {
+	tests := []struct {
+		tolerance *FailureTolerance
+		hostCount int
+		expected  int
+	}{
+		{nil, 5, 0},
+		{&FailureTolerance{}, 5, 0},
+		{&FailureTolerance{Hosts: 2}, 5, 2},
+		{&FailureTolerance{Hosts: 10}, 5, 4},
+		{&FailureTolerance{Hosts: 5}, 5, 4},
+		{&FailureTolerance{Hosts: 1}, 1, 0},
+		{&FailureTolerance{Percent: 25}, 8, 2},
+		{&FailureTolerance{Percent: 25}, 3, 0},
+		{&FailureTolerance{Percent: 50}, 5, 2},
+		{&FailureTolerance{Percent: 150}, 4, 3},
+		{&FailureTolerance{Percent: 100}, 4, 3},
+		{&FailureTolerance{Hosts: 1, Percent: 50}, 10, 1},
+		{&FailureTolerance{Hosts: 2}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		got := tt.tolerance.MaxFailedHosts(tt.hostCount)
+		if got != tt.expected {
+			t.Errorf("wrong number of hosts. tolerance=%+v, hosts=%d, want=%d, got=%d",
+				tt.tolerance, tt.hostCount, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A FreezeWindow is a recurring period in which deployments to a target are
+// blocked, e.g. every friday from 17:00 to 23:59.
+type FreezeWindow struct {
+	// The days on which the window starts, e.g. "friday" or "fri". If empty,
+	// the window starts every day.
+	Days []string `json:"days"`
+	// Start and end of the window as "HH:MM". If End is not after Start, the
+	// window lasts until End on the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// The IANA name of the time zone Start and End are in. Defaults to UTC.
+	Timezone string `json:"timezone"`
+	Reason   string `json:"reason"`
+}
+
+// Until returns whether the window is active at the given time and, if so,
+// when it ends.
+func (w *FreezeWindow) Until(now time.Time) (time.Time, bool, error) {
+	loc := time.UTC
+	if w.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(w.Timezone)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if end <= start {
+		end += 24 * time.Hour
+	}
+
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	// A window that started yesterday might still be active
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		matches, err := w.startsOn(day.Weekday())
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if !matches {
+			continue
+		}
+
+		windowStart := day.Add(start)
+		windowEnd := day.Add(end)
+		if !now.Before(windowStart) && now.Before(windowEnd) {
+			return windowEnd, true, nil
+		}
+	}
+
+	return time.Time{}, false, nil
+}
+
+func (w *FreezeWindow) startsOn(weekday time.Weekday) (bool, error) {
+	if len(w.Days) == 0 {
+		return true, nil
+	}
+
+	for _, d := range w.Days {
+		day, err := parseWeekday(d)
+		if err != nil {
+			return false, err
+		}
+		if day == weekday {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+
+	return time.Sunday, fmt.Errorf("unknown day in freeze window: %q", s)
+}
+
+// parseClock parses "HH:MM" into the duration since midnight. "24:00" is
+// allowed to end a window at midnight.
+func parseClock(s string) (time.Duration, error) {
+	var hours, minutes int
+
+	_, err := fmt.Sscanf(s, "%d:%d", &hours, &minutes)
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours > 24 ||
+		(hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time in freeze window: %q", s)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// A Freeze blocks deployments to a target until it expires or is lifted.
+// Freezes are either created by users or derived from a FreezeWindow, in which
+// case Id is 0.
+type Freeze struct {
+	Id              int
+	ApplicationName string
+	TargetName      string
+	UserId          int
+	User            *User
+	Reason          string
+	ExpiresAt       time.Time
+	CreatedAt       time.Time
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFreezeWindowUntil(t *testing.T) {
+	// 2016-03-11 is a friday
+	friday := func(hour, min int) time.Time {
+		return time.Date(2016, 3, 11, hour, min, 0, 0, time.UTC)
+	}
+
+	fridayEvening := &FreezeWindow{Days: []string{"friday"}, Start: "17:00", End: "24:00"}
+	weekend := &FreezeWindow{Days: []string{"Fri"}, Start: "18:00", End: "08:00"}
+	daily := &FreezeWindow{Start: "12:00", End: "13:00"}
+	berlin := &FreezeWindow{Days: []string{"friday"}, Start: "17:00", End: "18:00", Timezone: "Europe/Berlin"}
+
+	tests := []struct {
+		window      *FreezeWindow
+		now         time.Time
+		active      bool
+		expectedEnd time.Time
+	}{
+		{fridayEvening, friday(16, 59), false, time.Time{}},
+		{fridayEvening, friday(17, 0), true, friday(24, 0)},
+		{fridayEvening, friday(23, 59), true, friday(24, 0)},
+		{fridayEvening, friday(24, 0), false, time.Time{}},
+		{weekend, friday(20, 0), true, friday(32, 0)},
+		{weekend, friday(31, 59), true, friday(32, 0)},
+		{weekend, friday(32, 0), false, time.Time{}},
+		{weekend, friday(7, 0), false, time.Time{}},
+		{daily, friday(12, 30), true, friday(13, 0)},
+		{daily, friday(36, 30), true, friday(37, 0)},
+		{berlin, friday(16, 30), true, friday(17, 0)},
+		{berlin, friday(17, 30), false, time.Time{}},
+	}
+
+	for i, tt := range tests {
+		end, active, err := tt.window.Until(tt.now)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if active != tt.active {
+			t.Errorf("%d: wrong result. want=%t, got=%t", i, tt.active, active)
+		}
+		if !end.Equal(tt.expectedEnd) {
+			t.Errorf("%d: wrong end. want=%s, got=%s", i, tt.expectedEnd, end)
+		}
+	}
+}
+
+func TestFreezeWindowUntilInvalid(t *testing.T) {
+	windows := []*FreezeWindow{
+		{Start: "17", End: "18:00"},
+		{Start: "17:00", End: "25:00"},
+		{Start: "17:00", End: "18:60"},
+		{Days: []string{"caturday"}, Start: "17:00", End: "18:00"},
+		{Start: "17:00", End: "18:00", Timezone: "Mars/Olympus_Mons"},
+	}
+
+	for _, w := range windows {
+		_, _, err := w.Until(time.Now())
+		if err == nil {
+			t.Errorf("expected error for window %+v", w)
+		}
+	}
+}
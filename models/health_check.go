@@ -0,0 +1,96 @@
+package models
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+)
+
+// The VERIFY stage runs the health checks of the roles and the target after
+// the deployment, e.g. after POST_DEPLOYMENT. If they don't pass, the
+// deployment fails and is rolled back.
+const STAGE_VERIFY DeploymentStage = "VERIFY"
+
+const (
+	defaultHealthCheckStatus   = 200
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 10 * time.Second
+)
+
+// HealthCheck is an HTTP endpoint that is polled in the VERIFY stage until it
+// responds with the expected status and body or the retries are exhausted.
+type HealthCheck struct {
+	// URL and ExpectedBody are templates, rendered with the script options
+	// and the name of the host without the port as Host
+	URL string `json:"url"`
+	// Defaults to 200
+	ExpectedStatus int `json:"expected_status"`
+	// If set, the response body has to contain it
+	ExpectedBody string `json:"expected_body"`
+	// Request the URL through the SSH connection of the host, so it's
+	// resolved on the host, e.g. http://localhost:8080/health. Only used for
+	// the health checks of roles.
+	ViaSSH bool `json:"via_ssh"`
+	// How often a failed check is retried
+	Retries int `json:"retries"`
+	// Seconds between two attempts, defaults to 5
+	IntervalSeconds int `json:"interval_seconds"`
+	// Seconds after which a single request is aborted, defaults to 10
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+func (hc *HealthCheck) Status() int {
+	if hc.ExpectedStatus == 0 {
+		return defaultHealthCheckStatus
+	}
+	return hc.ExpectedStatus
+}
+
+func (hc *HealthCheck) Interval() time.Duration {
+	if hc.IntervalSeconds <= 0 {
+		return defaultHealthCheckInterval
+	}
+	return time.Duration(hc.IntervalSeconds) * time.Second
+}
+
+func (hc *HealthCheck) Timeout() time.Duration {
+	if hc.TimeoutSeconds <= 0 {
+		return defaultHealthCheckTimeout
+	}
+	return time.Duration(hc.TimeoutSeconds) * time.Second
+}
+
+// Render returns a copy of the health check with URL and ExpectedBody
+// rendered with the options.
+func (hc *HealthCheck) Render(options map[string]string) (*HealthCheck, error) {
+	rendered := *hc
+
+	var err error
+	rendered.URL, err = renderTemplate("url", hc.URL, options)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered.ExpectedBody, err = renderTemplate("expected_body", hc.ExpectedBody, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rendered, nil
+}
+
+func renderTemplate(name, text string, options map[string]string) (string, error) {
+	var b bytes.Buffer
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	err = tmpl.Execute(&b, options)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
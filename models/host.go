@@ -1,6 +1,27 @@
 package models
 
+// A host with this name is not connected to over SSH. Its scripts are
+// executed on the Applikatoni host itself.
+const LOCAL_HOST = "local"
+
 type Host struct {
 	Name  string   `json:"name"`
 	Roles []string `json:"roles"`
+	// If set, overrides the JumpHosts of the target. An empty list means
+	// that the host is reachable directly.
+	JumpHosts []*JumpHost `json:"jump_hosts"`
+}
+
+// IsLocal returns true if the host is the Applikatoni host itself.
+func (h *Host) IsLocal() bool {
+	return h.Name == LOCAL_HOST
+}
+
+// JumpHost is a bastion host through which the SSH connections to the hosts
+// are tunneled. If User or SshKey are empty the DeploymentUser and
+// DeploymentSshKey of the target are used.
+type JumpHost struct {
+	Name   string `json:"name"`
+	User   string `json:"user"`
+	SshKey string `json:"ssh_key"`
 }
@@ -0,0 +1,23 @@
+package models
+
+// A PipelineStage is a target in the pipeline of an application. Commits are
+// promoted from one stage to the next after a successful deployment.
+type PipelineStage struct {
+	Target string `json:"target"`
+	// If true, a successful deployment to the previous stage is promoted to
+	// this stage automatically. Otherwise the promotion is only offered.
+	AutoPromote bool `json:"auto_promote"`
+}
+
+// NextPipelineStage returns the stage that deployments to the given target
+// are promoted to, or nil if the target is the last stage or not part of the
+// pipeline.
+func (a *Application) NextPipelineStage(targetName string) *PipelineStage {
+	for i, s := range a.Pipeline {
+		if s.Target == targetName && i+1 < len(a.Pipeline) {
+			return a.Pipeline[i+1]
+		}
+	}
+
+	return nil
+}
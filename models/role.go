@@ -2,18 +2,120 @@ package models
 
 import "bytes"
 import "text/template"
+import "time"
 
 type DeploymentStage string
 
+// The script for this stage is not executed as part of a deployment, but only
+// if a deployment fails, on all hosts that were already touched.
+const STAGE_ROLLBACK DeploymentStage = "ROLLBACK"
+
+// The value secret options are replaced with when scripts are shown to users
+const MaskedValue = "****"
+
+// In this script mode the whole script of a stage is executed in a single bash
+// session, instead of every line in its own session.
+const SCRIPT_MODE_SHELL = "shell"
+
 type Role struct {
 	Name            string                     `json:"name"`
 	ScriptTemplates map[DeploymentStage]string `json:"script_templates"`
 	Options         map[string]string          `json:"options"`
+	SecretOptions   []string                   `json:"secret_options"`
+	// Seconds after which the script of a stage is aborted on a host
+	StageTimeouts map[DeploymentStage]int `json:"stage_timeouts"`
+	// Checked on every host with this role in the VERIFY stage
+	HealthChecks []*HealthCheck `json:"health_checks"`
+	// Stages whose script is executed on only one of the hosts with this
+	// role, e.g. database migrations
+	RunOnce []DeploymentStage `json:"run_once"`
+	// Commands of a stage's script, matched by prefix, whose failure is
+	// logged but doesn't stop the script
+	ContinueOnError map[DeploymentStage][]string `json:"continue_on_error"`
+	// Either empty, to execute scripts line by line, or SCRIPT_MODE_SHELL
+	ScriptMode string `json:"script_mode"`
+	// Environment variables of the scripts, see ResolveEnv
+	Env map[string]string `json:"env"`
+}
+
+// StageTimeout returns the timeout of the stage, or 0 if it has none.
+func (r *Role) StageTimeout(stage DeploymentStage) time.Duration {
+	return time.Duration(r.StageTimeouts[stage]) * time.Second
 }
 
 func (r *Role) RenderScripts(options map[string]string) (map[DeploymentStage]string, error) {
-	rendered := make(map[DeploymentStage]string)
 	mergedOptions := mergeOptions(copyOptions(r.Options), options)
+	return r.renderScripts(mergedOptions)
+}
+
+// RenderMaskedScripts renders the script templates just like RenderScripts,
+// but with the values of all SecretOptions replaced by MaskedValue.
+func (r *Role) RenderMaskedScripts(options map[string]string) (map[DeploymentStage]string, error) {
+	return r.renderScripts(r.maskedOptions(options))
+}
+
+// RenderHealthChecks renders the health checks of the role with the options,
+// in the same way the scripts are rendered.
+func (r *Role) RenderHealthChecks(options map[string]string) ([]*HealthCheck, error) {
+	mergedOptions := mergeOptions(copyOptions(r.Options), options)
+	return r.renderHealthChecks(mergedOptions)
+}
+
+// RenderMaskedHealthChecks renders the health checks with the values of all
+// SecretOptions replaced by MaskedValue.
+func (r *Role) RenderMaskedHealthChecks(options map[string]string) ([]*HealthCheck, error) {
+	return r.renderHealthChecks(r.maskedOptions(options))
+}
+
+// SecretValues returns the values of the SecretOptions, merged with the
+// options like in RenderScripts.
+func (r *Role) SecretValues(options map[string]string) []string {
+	mergedOptions := mergeOptions(copyOptions(r.Options), options)
+
+	values := []string{}
+	for _, name := range r.SecretOptions {
+		if value, ok := mergedOptions[name]; ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// MaskedOptions returns a copy of the options of the role with the values of
+// all SecretOptions replaced by MaskedValue.
+func (r *Role) MaskedOptions() map[string]string {
+	if r.Options == nil {
+		return nil
+	}
+	return r.maskedOptions(nil)
+}
+
+func (r *Role) maskedOptions(options map[string]string) map[string]string {
+	mergedOptions := mergeOptions(copyOptions(r.Options), options)
+	for _, name := range r.SecretOptions {
+		if _, ok := mergedOptions[name]; ok {
+			mergedOptions[name] = MaskedValue
+		}
+	}
+	return mergedOptions
+}
+
+func (r *Role) renderHealthChecks(mergedOptions map[string]string) ([]*HealthCheck, error) {
+	rendered := []*HealthCheck{}
+
+	for _, hc := range r.HealthChecks {
+		check, err := hc.Render(mergedOptions)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, check)
+	}
+
+	return rendered, nil
+}
+
+func (r *Role) renderScripts(mergedOptions map[string]string) (map[DeploymentStage]string, error) {
+	rendered := make(map[DeploymentStage]string)
 
 	for stage, scriptTemplate := range r.ScriptTemplates {
 		var b bytes.Buffer
@@ -72,3 +72,49 @@ func TestRender(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderMaskedScripts(t *testing.T) {
+	role := &Role{
+		ScriptTemplates: map[DeploymentStage]string{
+			DeploymentStage("CODE_DEPLOYMENT"): "deploy {{.CommitSha}} --token {{.ApiToken}}",
+		},
+		Options:       map[string]string{"ApiToken": "s3cr3t"},
+		SecretOptions: []string{"ApiToken", "NotSet"},
+	}
+
+	masked, err := role.RenderMaskedScripts(otherOptions)
+	if err != nil {
+		t.Fatalf("RenderMaskedScripts returned error. err=%s", err)
+	}
+
+	expected := fmt.Sprintf("deploy %s --token %s", otherOptions["CommitSha"], MaskedValue)
+	if masked["CODE_DEPLOYMENT"] != expected {
+		t.Errorf("Rendering wrong. expected='%s', got='%s'", expected, masked["CODE_DEPLOYMENT"])
+	}
+
+	if role.Options["ApiToken"] != "s3cr3t" {
+		t.Errorf("role.Options[ApiToken] was changed. got=%s", role.Options["ApiToken"])
+	}
+
+	rendered, err := role.RenderScripts(otherOptions)
+	if err != nil {
+		t.Fatalf("RenderScripts returned error. err=%s", err)
+	}
+
+	expected = fmt.Sprintf("deploy %s --token s3cr3t", otherOptions["CommitSha"])
+	if rendered["CODE_DEPLOYMENT"] != expected {
+		t.Errorf("Rendering wrong. expected='%s', got='%s'", expected, rendered["CODE_DEPLOYMENT"])
+	}
+}
+
+func TestSecretValues(t *testing.T) {
+	role := &Role{
+		Options:       map[string]string{"ApiToken": "s3cr3t", "Public": "visible"},
+		SecretOptions: []string{"ApiToken", "Password", "NotSet"},
+	}
+
+	values := role.SecretValues(map[string]string{"Password": "hunter2"})
+	if len(values) != 2 || values[0] != "s3cr3t" || values[1] != "hunter2" {
+		t.Errorf("wrong secret values. want=%v, got=%v", []string{"s3cr3t", "hunter2"}, values)
+	}
+}
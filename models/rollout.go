@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// RolloutStrategy controls on how many hosts of a target a stage is executed
+// at the same time. If neither BatchSize nor BatchPercent is set, a stage is
+// executed on all hosts at once.
+type RolloutStrategy struct {
+	BatchSize    int `json:"batch_size"`
+	BatchPercent int `json:"batch_percent"`
+	PauseSeconds int `json:"pause_seconds"`
+}
+
+// HostsPerBatch returns how many of the given number of hosts execute a stage
+// at the same time. It never returns less than 1 or more than hostCount.
+func (rs *RolloutStrategy) HostsPerBatch(hostCount int) int {
+	if rs == nil || hostCount < 1 {
+		return hostCount
+	}
+
+	size := hostCount
+	if rs.BatchSize > 0 {
+		size = rs.BatchSize
+	} else if rs.BatchPercent > 0 {
+		// Round up, so that 25% of 3 hosts still means 1 host per batch
+		size = (hostCount*rs.BatchPercent + 99) / 100
+	}
+
+	if size < 1 {
+		return 1
+	}
+	if size > hostCount {
+		return hostCount
+	}
+	return size
+}
+
+// Pause returns the time to wait between two batches.
+func (rs *RolloutStrategy) Pause() time.Duration {
+	if rs == nil {
+		return 0
+	}
+	return time.Duration(rs.PauseSeconds) * time.Second
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostsPerBatch(t *testing.T) {
+	tests := []struct {
+		strategy  *RolloutStrategy
+		hostCount int
+		expected  int
+	}{
+		{nil, 5, 5},
+		{&RolloutStrategy{}, 5, 5},
+		{&RolloutStrategy{BatchSize: 2}, 5, 2},
+		{&RolloutStrategy{BatchSize: 10}, 5, 5},
+		{&RolloutStrategy{BatchPercent: 25}, 8, 2},
+		{&RolloutStrategy{BatchPercent: 25}, 3, 1},
+		{&RolloutStrategy{BatchPercent: 50}, 5, 3},
+		{&RolloutStrategy{BatchPercent: 150}, 4, 4},
+		{&RolloutStrategy{BatchSize: 2, BatchPercent: 50}, 10, 2},
+		{&RolloutStrategy{BatchSize: 2}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		got := tt.strategy.HostsPerBatch(tt.hostCount)
+		if got != tt.expected {
+			t.Errorf("wrong batch size. strategy=%+v, hosts=%d, want=%d, got=%d",
+				tt.strategy, tt.hostCount, tt.expected, got)
+		}
+	}
+}
+
+func TestRolloutPause(t *testing.T) {
+	var nilStrategy *RolloutStrategy
+	if nilStrategy.Pause() != 0 {
+		t.Errorf("nil strategy should not pause. got=%s", nilStrategy.Pause())
+	}
+
+	rs := &RolloutStrategy{PauseSeconds: 30}
+	if rs.Pause() != 30*time.Second {
+		t.Errorf("wrong pause. want=%s, got=%s", 30*time.Second, rs.Pause())
+	}
+}
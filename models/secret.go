@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A configuration value with this prefix is read from the secrets stored in
+// Applikatoni, e.g. "secret://prod-ssh-key".
+const SECRET_REFERENCE_PREFIX = "secret://"
+
+// Secret is a value stored encrypted by Applikatoni. Its value is never
+// loaded with it.
+type Secret struct {
+	Id        int
+	Name      string
+	UserId    int
+	User      *User
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SecretResolver returns the value of the secret with the given name.
+type SecretResolver func(name string) (string, error)
+
+// IsSecretReference reports whether the value refers to a stored secret.
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, SECRET_REFERENCE_PREFIX)
+}
+
+// ResolveSecret returns the value of the secret the value refers to, or the
+// value itself if it's no reference.
+func ResolveSecret(value string, secrets SecretResolver) (string, error) {
+	if !IsSecretReference(value) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, SECRET_REFERENCE_PREFIX)
+	if secrets == nil {
+		return "", fmt.Errorf("secret %s referenced, but no secrets are available", name)
+	}
+	return secrets(name)
+}
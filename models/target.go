@@ -1,26 +1,92 @@
 package models
 
+import "time"
+
+// HostKeyMode controls how the host keys of a target's hosts are verified
+type HostKeyMode string
+
+const (
+	// Only host keys configured in host_keys or known_hosts_file are accepted
+	HOST_KEY_STRICT HostKeyMode = "strict"
+	// The keys of hosts that are not configured are pinned the first time
+	// they are seen and must match on every following deployment
+	HOST_KEY_ACCEPT_NEW HostKeyMode = "accept-new"
+)
+
 type Target struct {
-	Name             string            `json:"name"`
-	DeploymentUser   string            `json:"deployment_user"`
-	DeploymentSshKey string            `json:"deployment_ssh_key"`
-	DeployUsernames  []string          `json:"deploy_usernames"`
-	Hosts            []*Host           `json:"hosts"`
-	Roles            []*Role           `json:"roles"`
-	AvailableStages  []DeploymentStage `json:"available_stages"`
-	DefaultStages    []DeploymentStage `json:"default_stages"`
-	BugsnagApiKey    string            `json:"bugsnag_api_key"`
-	FlowdockEndpoint string            `json:"flowdock_endpoint"`
-	NewRelicApiKey   string            `json:"new_relic_api_key"`
-	NewRelicAppId    string            `json:"new_relic_app_id"`
-	SlackUrl         string            `json:"slack_url"`
-	Webhooks         []string          `json:"webhooks"`
+	Name                           string                                `json:"name"`
+	DeploymentUser                 string                                `json:"deployment_user"`
+	DeploymentSshKey               string                                `json:"deployment_ssh_key"`
+	DeploymentSshKeyFile           string                                `json:"deployment_ssh_key_file"`
+	DeploymentSshKeyPassphraseEnv  string                                `json:"deployment_ssh_key_passphrase_env"`
+	DeploymentSshKeyPassphraseFile string                                `json:"deployment_ssh_key_passphrase_file"`
+	DeploymentSshCertificate       string                                `json:"deployment_ssh_certificate"`
+	DeploymentSshCertificateFile   string                                `json:"deployment_ssh_certificate_file"`
+	ForwardSshAgent                bool                                  `json:"forward_ssh_agent"`
+	DeployUsernames                []string                              `json:"deploy_usernames"`
+	Hosts                          []*Host                               `json:"hosts"`
+	Roles                          []*Role                               `json:"roles"`
+	AvailableStages                []DeploymentStage                     `json:"available_stages"`
+	DefaultStages                  []DeploymentStage                     `json:"default_stages"`
+	BugsnagApiKey                  string                                `json:"bugsnag_api_key"`
+	FlowdockEndpoint               string                                `json:"flowdock_endpoint"`
+	NewRelicApiKey                 string                                `json:"new_relic_api_key"`
+	NewRelicAppId                  string                                `json:"new_relic_app_id"`
+	SlackUrl                       string                                `json:"slack_url"`
+	Webhooks                       []string                              `json:"webhooks"`
+	Rollout                        *RolloutStrategy                      `json:"rollout"`
+	HostKeys                       []string                              `json:"host_keys"`
+	KnownHostsFile                 string                                `json:"known_hosts_file"`
+	HostKeyMode                    HostKeyMode                           `json:"host_key_mode"`
+	JumpHosts                      []*JumpHost                           `json:"jump_hosts"`
+	DeploymentTimeoutSeconds       int                                   `json:"deployment_timeout_seconds"`
+	KillGracePeriodSeconds         int                                   `json:"kill_grace_period_seconds"`
+	RequiredApprovals              int                                   `json:"required_approvals"`
+	Approvers                      []string                              `json:"approvers"`
+	FreezeWindows                  []*FreezeWindow                       `json:"freeze_windows"`
+	FreezeOverrideUsernames        []string                              `json:"freeze_override_usernames"`
+	AutoDeployBranch               string                                `json:"auto_deploy_branch"`
+	AutoDeployUsername             string                                `json:"auto_deploy_username"`
+	AutoDeployRequireCI            bool                                  `json:"auto_deploy_require_ci"`
+	RequiredStatusChecks           []string                              `json:"required_status_checks"`
+	HealthChecks                   []*HealthCheck                        `json:"health_checks"`
+	Artifact                       *Artifact                             `json:"artifact"`
+	MaxFailedHosts                 map[DeploymentStage]*FailureTolerance `json:"max_failed_hosts"`
+	Env                            map[string]string                     `json:"env"`
+	RedactPatterns                 []string                              `json:"redact_patterns"`
+}
+
+// The time a killed or timed out command has to exit before it's sent SIGKILL,
+// if the target doesn't set kill_grace_period_seconds
+const DefaultKillGracePeriod = 10 * time.Second
+
+// KillGracePeriod returns how long a killed or timed out command has to exit
+// after it was sent SIGTERM, before it is sent SIGKILL.
+func (t *Target) KillGracePeriod() time.Duration {
+	if t.KillGracePeriodSeconds <= 0 {
+		return DefaultKillGracePeriod
+	}
+	return time.Duration(t.KillGracePeriodSeconds) * time.Second
 }
 
 func (t *Target) IsDeployer(userName string) bool {
 	return isInList(userName, t.DeployUsernames)
 }
 
+func (t *Target) IsApprover(userName string) bool {
+	return isInList(userName, t.Approvers)
+}
+
+func (t *Target) CanOverrideFreeze(userName string) bool {
+	return isInList(userName, t.FreezeOverrideUsernames)
+}
+
+// NeedsApproval returns true if deployments to the target have to be
+// approved before they are started.
+func (t *Target) NeedsApproval() bool {
+	return t.RequiredApprovals > 0
+}
+
 func (t *Target) IsDefaultStage(s DeploymentStage) bool {
 	for _, def := range t.DefaultStages {
 		if def == s {
@@ -106,7 +106,12 @@ $(function() {
   var logEntryDeploymentStartTemplate   = Hogan.compile($('#logEntryDeploymentStartTemplate').text(), hoganOptions);
   var logEntryDeploymentFailTemplate    = Hogan.compile($('#logEntryDeploymentFailTemplate').text(), hoganOptions);
   var logEntryDeploymentSuccessTemplate = Hogan.compile($('#logEntryDeploymentSuccessTemplate').text(), hoganOptions);
+  var logEntryDeploymentPartialTemplate = Hogan.compile($('#logEntryDeploymentPartialTemplate').text(), hoganOptions);
   var logEntryKillReceivedTemplate      = Hogan.compile($('#logEntryKillReceivedTemplate').text(), hoganOptions);
+  var logEntryDeploymentKilledTemplate  = Hogan.compile($('#logEntryDeploymentKilledTemplate').text(), hoganOptions);
+  var logEntryRollbackStartTemplate     = Hogan.compile($('#logEntryRollbackStartTemplate').text(), hoganOptions);
+  var logEntryRollbackFailTemplate      = Hogan.compile($('#logEntryRollbackFailTemplate').text(), hoganOptions);
+  var logEntryRollbackSuccessTemplate   = Hogan.compile($('#logEntryRollbackSuccessTemplate').text(), hoganOptions);
 
   var logEntryTemplates = {
     'COMMAND_STDOUT_OUTPUT':   logEntryStdoutTemplate,
@@ -120,8 +125,13 @@ $(function() {
     'STAGE_RESULT':            logEntryStageResultTemplate,
     'DEPLOYMENT_START':        logEntryDeploymentStartTemplate,
     'DEPLOYMENT_SUCCESS':      logEntryDeploymentSuccessTemplate,
+    'DEPLOYMENT_PARTIAL':      logEntryDeploymentPartialTemplate,
     'DEPLOYMENT_FAIL':         logEntryDeploymentFailTemplate,
-    'KILL_RECEIVED':           logEntryKillReceivedTemplate
+    'KILL_RECEIVED':           logEntryKillReceivedTemplate,
+    'DEPLOYMENT_KILLED':       logEntryDeploymentKilledTemplate,
+    'ROLLBACK_START':          logEntryRollbackStartTemplate,
+    'ROLLBACK_FAIL':           logEntryRollbackFailTemplate,
+    'ROLLBACK_SUCCESS':        logEntryRollbackSuccessTemplate
   };
 
   var labelClasses = function (index, css) {
@@ -140,6 +150,11 @@ $(function() {
   var path        = $('.deployment-info').data('log-path');
   var $killButton = $('.kill-button');
 
+  // Queued deployments have no logs yet. Reload the page until they start.
+  if (state === 'queued') {
+    setTimeout(function() { window.location.reload(); }, 5000);
+  }
+
   if (path) {
     resizeLogs();
     $(window).resize(resizeLogs);
@@ -172,12 +187,22 @@ $(function() {
         Favicon.stopRotation();
         stateInfo.removeClass(labelClasses).addClass('label-success').text('Successful');
         $killButton.remove();
+      } else if (type === 'DEPLOYMENT_PARTIAL') {
+        Favicon.stopRotation();
+        stateInfo.removeClass(labelClasses).addClass('label-warning').text('Partially successful');
+        $killButton.remove();
       } else if (type === 'DEPLOYMENT_FAIL') {
         Favicon.stopRotation();
         stateInfo.removeClass(labelClasses).addClass('label-danger').text('Failed');
         $killButton.remove();
       } else if (type === 'KILL_RECEIVED') {
         $killButton.attr('disabled', true);
+      } else if (type === 'DEPLOYMENT_KILLED') {
+        Favicon.stopRotation();
+        stateInfo.removeClass(labelClasses).addClass('label-danger').text('Killed');
+        $killButton.remove();
+      } else if (type === 'ROLLBACK_SUCCESS') {
+        stateInfo.removeClass(labelClasses).addClass('label-warning').text('Rolled back');
       }
     };
   }
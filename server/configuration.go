@@ -17,9 +17,23 @@ type Configuration struct {
 	MandrillAPIKey     string                `json:"mandrill_api_key"`
 	MailgunBaseURL     string                `json:"mailgun_base_url"`
 	MailgunAPIKey      string                `json:"mailgun_api_key"`
+	SecretsKeyEnv      string                `json:"secrets_key_env"`
+	SecretsKeyFile     string                `json:"secrets_key_file"`
+	AdminUsernames     []string              `json:"admin_usernames"`
 	Applications       []*models.Application `json:"applications"`
 }
 
+// IsAdmin returns true if the user with the name is allowed to manage the
+// stored secrets.
+func (c *Configuration) IsAdmin(name string) bool {
+	for _, admin := range c.AdminUsernames {
+		if admin == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Configuration) DailyDigestSender() DailyDigestSender {
 	if c.MailgunBaseURL != "" && c.MailgunAPIKey != "" {
 		return NewMailgunClient(c.MailgunBaseURL, c.MailgunAPIKey)
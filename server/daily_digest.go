@@ -30,7 +30,8 @@ Check out what the team behind {{.Application.Name}} deployed in the last 24 hou
 {{ range .Deployments }}
 {{.CreatedAt.Format "02.01.2006 15:04 (MST)"}} -- {{.User.Name}} deployed to {{.TargetName}} with the following message:
     {{.Comment}}
-{{ end}}
+{{ if .Stages }}    Stages: {{range $i, $s := .Stages}}{{if $i}}, {{end}}{{$s}}{{end}}
+{{ end }}{{ end}}
 
 Always at your service:
 your Applikatoni Daily Digest Team
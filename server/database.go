@@ -17,62 +17,518 @@ import (
 )
 
 const (
-	deploymentStmt                     = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.id = ?`
-	deploymentInsertStmt               = `INSERT INTO deployments (user_id, application_name, target_name, commit_sha, branch, comment, state, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+	deploymentStmt                     = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, stages, killed_by, freeze_override, status_checks_override, scheduled_at, promoted_from, failed_hosts, created_at FROM deployments WHERE deployments.id = ?`
+	deploymentInsertStmt               = `INSERT INTO deployments (user_id, application_name, target_name, commit_sha, branch, comment, state, stages, freeze_override, status_checks_override, scheduled_at, promoted_from, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	deploymentUpdateStateStmt          = `UPDATE deployments SET state = ? WHERE deployments.id = ?`
+	deploymentUpdateKilledByStmt       = `UPDATE deployments SET killed_by = ? WHERE deployments.id = ?`
+	deploymentUpdateFailedHostsStmt    = `UPDATE deployments SET failed_hosts = ? WHERE deployments.id = ?`
 	deploymentFailUnfinishedStmt       = `UPDATE deployments SET state = ? WHERE deployments.state = ? OR deployments.state = ?`
-	lastTargetDeploymentStmt           = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.state = ? AND deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC LIMIT 1`
-	applicationDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.application_name = ? ORDER BY created_at DESC LIMIT ?`
-	applicationDeploymentsByTargetStmt = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC`
+	lastTargetDeploymentStmt           = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, stages, killed_by, freeze_override, status_checks_override, scheduled_at, promoted_from, failed_hosts, created_at FROM deployments WHERE deployments.state IN (?, ?) AND deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC LIMIT 1`
+	applicationDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, stages, created_at FROM deployments WHERE deployments.application_name = ? ORDER BY created_at DESC LIMIT ?`
+	applicationDeploymentsByTargetStmt = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, stages, created_at FROM deployments WHERE deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC`
 	logEntryInsertStmt                 = `INSERT INTO log_entries (deployment_id, entry_type, origin, message, timestamp, created_at) VALUES (?, ?, ?, ?, ?, ?);`
 	deploymentLogEntriesStmt           = `SELECT id, deployment_id, entry_type, origin, message, timestamp FROM log_entries WHERE log_entries.deployment_id = ? ORDER BY timestamp ASC`
+	deploymentScriptInsertStmt         = `INSERT INTO deployment_scripts (deployment_id, host, stage, script, created_at) VALUES (?, ?, ?, ?, ?);`
+	deploymentScriptsStmt              = `SELECT id, deployment_id, host, stage, script FROM deployment_scripts WHERE deployment_scripts.deployment_id = ? ORDER BY id ASC`
+	hostKeyStmt                        = `SELECT public_key FROM host_keys WHERE host = ?;`
+	hostKeyInsertStmt                  = `INSERT INTO host_keys (host, public_key, created_at) VALUES (?, ?, ?);`
 	userInsertStmt                     = `INSERT INTO users(id, name, access_token, avatar_url, api_token) VALUES(?, ?, ?, ?, ?);`
 	userUpdateStmt                     = `UPDATE users SET access_token = ?, avatar_url = ? WHERE id = ?;`
 	userStmt                           = `SELECT id, name, access_token, avatar_url, api_token FROM users WHERE id = ?;`
+	userNameStmt                       = `SELECT id, name, access_token, avatar_url, api_token FROM users WHERE name = ?;`
 	userApiTokenStmt                   = `SELECT id, name, access_token, avatar_url, api_token FROM users WHERE api_token = ?;`
-	activeDeploymentsStmt              = `SELECT state FROM deployments WHERE application_name = ? AND target_name = ? AND state = 'active' LIMIT 1;`
-	dailyDigestDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE state = 'successful' AND application_name = ? AND target_name = ? AND created_at > ? ORDER BY created_at ASC;`
+	activeDeploymentsStmt              = `SELECT state FROM deployments WHERE application_name = ? AND target_name = ? AND state IN ('new', 'active') LIMIT 1;`
+	nextQueuedDeploymentStmt           = `SELECT id FROM deployments WHERE application_name = ? AND target_name = ? AND state = 'queued' ORDER BY id ASC LIMIT 1;`
+	deploymentDequeueStmt              = `UPDATE deployments SET state = 'new' WHERE deployments.id = ? AND deployments.state = 'queued'`
+	deploymentCancelStmt               = `UPDATE deployments SET state = 'cancelled' WHERE deployments.id = ? AND deployments.state IN ('queued', 'scheduled', 'pending_approval')`
+	deploymentStateStmt                = `SELECT state FROM deployments WHERE deployments.id = ?`
+	deploymentDecideStmt               = `UPDATE deployments SET state = ? WHERE deployments.id = ? AND deployments.state = 'pending_approval'`
+	approvalInsertStmt                 = `INSERT INTO approvals (deployment_id, user_id, approved, comment, created_at) VALUES (?, ?, ?, ?, ?);`
+	approvalExistsStmt                 = `SELECT COUNT(1) FROM approvals WHERE deployment_id = ? AND user_id = ?;`
+	approvalCountStmt                  = `SELECT COUNT(1) FROM approvals WHERE deployment_id = ? AND approved = 1;`
+	deploymentApprovalsStmt            = `SELECT approvals.id, approvals.deployment_id, approvals.user_id, approvals.approved, approvals.comment, approvals.created_at, users.name, users.avatar_url FROM approvals JOIN users ON users.id = approvals.user_id WHERE approvals.deployment_id = ? ORDER BY approvals.id ASC`
+	freezeInsertStmt                   = `INSERT INTO freezes (application_name, target_name, user_id, reason, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?);`
+	freezeStmt                         = `SELECT freezes.id, freezes.application_name, freezes.target_name, freezes.user_id, freezes.reason, freezes.expires_at, freezes.created_at, users.name, users.avatar_url FROM freezes JOIN users ON users.id = freezes.user_id WHERE freezes.id = ?`
+	activeFreezesStmt                  = `SELECT freezes.id, freezes.application_name, freezes.target_name, freezes.user_id, freezes.reason, freezes.expires_at, freezes.created_at, users.name, users.avatar_url FROM freezes JOIN users ON users.id = freezes.user_id WHERE freezes.application_name = ? AND freezes.lifted_by = '' AND freezes.expires_at > ? ORDER BY freezes.expires_at DESC`
+	freezeLiftStmt                     = `UPDATE freezes SET lifted_by = ? WHERE freezes.id = ?`
+	dueDeploymentsStmt                 = `SELECT id FROM deployments WHERE state = 'scheduled' AND scheduled_at <= ? ORDER BY scheduled_at ASC, id ASC`
+	deploymentReleaseStmt              = `UPDATE deployments SET state = ? WHERE deployments.id = ? AND deployments.state = 'scheduled'`
+	deploymentRescheduleStmt           = `UPDATE deployments SET scheduled_at = ? WHERE deployments.id = ? AND deployments.state = 'scheduled'`
+	userCommitDeploymentsStmt          = `SELECT COUNT(1) FROM deployments WHERE application_name = ? AND target_name = ? AND commit_sha = ? AND user_id = ? AND state IN ('new', 'queued', 'scheduled', 'pending_approval', 'active', 'successful', 'partially_successful');`
+	queuePositionStmt                  = `SELECT COUNT(1) FROM deployments WHERE application_name = ? AND target_name = ? AND state = 'queued' AND id <= ?;`
+	dailyDigestDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, stages, created_at FROM deployments WHERE state IN ('successful', 'partially_successful') AND application_name = ? AND target_name = ? AND created_at > ? ORDER BY created_at ASC;`
+	secretsStmt                        = `SELECT secrets.id, secrets.name, secrets.user_id, secrets.created_at, secrets.updated_at, users.name, users.avatar_url FROM secrets JOIN users ON users.id = secrets.user_id ORDER BY secrets.name ASC`
+	secretValueStmt                    = `SELECT value FROM secrets WHERE name = ?;`
+	secretInsertStmt                   = `INSERT INTO secrets (name, value, user_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?);`
+	secretUpdateStmt                   = `UPDATE secrets SET value = ?, user_id = ?, updated_at = ? WHERE name = ?;`
+	secretDeleteStmt                   = `DELETE FROM secrets WHERE name = ?;`
 )
 
-var ErrDeployInProgress = errors.New("another deployment to target already in progress")
+var (
+	ErrNotWaiting         = errors.New("deployment is not waiting anymore")
+	ErrNotPendingApproval = errors.New("deployment is not pending approval")
+	ErrAlreadyDecided     = errors.New("user already approved or rejected the deployment")
+	ErrNotScheduled       = errors.New("deployment is not scheduled")
+)
 
+// createDeployment saves a new deployment. If another deployment to the same
+// target is running or already waiting, the deployment is saved in state
+// 'queued' instead of 'new'.
 func createDeployment(db *sql.DB, d *models.Deployment) error {
-	var id int64
 	var state models.DeploymentState = models.DEPLOYMENT_NEW
-	var createdAt time.Time = time.Now()
 
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	exists, err := activeDeploymentExists(tx, d.ApplicationName, d.TargetName)
+	busy, err := targetBusy(tx, d.ApplicationName, d.TargetName)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-	if exists {
+	if busy {
+		state = models.DEPLOYMENT_QUEUED
+	}
+
+	err = insertDeployment(tx, d, state)
+	if err != nil {
 		tx.Rollback()
-		return ErrDeployInProgress
+		return err
 	}
 
-	result, err := tx.Exec(deploymentInsertStmt, d.UserId, d.ApplicationName,
-		d.TargetName, d.CommitSha, d.Branch, d.Comment, string(state), createdAt)
+	return tx.Commit()
+}
+
+// createPendingDeployment saves a new deployment in state 'pending_approval'.
+// It is queued once it has been approved, see decideDeployment.
+func createPendingDeployment(db *sql.DB, d *models.Deployment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = insertDeployment(tx, d, models.DEPLOYMENT_PENDING_APPROVAL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createScheduledDeployment saves a new deployment in state 'scheduled'. It is
+// released at d.ScheduledAt, see releaseScheduledDeployment.
+func createScheduledDeployment(db *sql.DB, d *models.Deployment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	scheduledAt := d.ScheduledAt.UTC()
+	d.ScheduledAt = &scheduledAt
+
+	err = insertDeployment(tx, d, models.DEPLOYMENT_SCHEDULED)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getDueScheduledDeployments returns the scheduled deployments that are due
+// at the given time, those that were due first first.
+func getDueScheduledDeployments(db *sql.DB, now time.Time) ([]*models.Deployment, error) {
+	rows, err := db.Query(dueDeploymentsStmt, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deployments := []*models.Deployment{}
+	for _, id := range ids {
+		d, err := getDeployment(db, id)
+		if err != nil {
+			return deployments, err
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments, nil
+}
+
+// releaseScheduledDeployment moves a due scheduled deployment to the state it
+// would have had if it had been created now: 'pending_approval' if approvals
+// are needed, 'queued' if the target is busy and 'new' otherwise. It returns
+// ErrNotScheduled if the deployment was cancelled or released in the meantime.
+func releaseScheduledDeployment(db *sql.DB, d *models.Deployment, needsApproval bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	state := models.DEPLOYMENT_NEW
+	if needsApproval {
+		state = models.DEPLOYMENT_PENDING_APPROVAL
+	} else {
+		busy, err := targetBusy(tx, d.ApplicationName, d.TargetName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if busy {
+			state = models.DEPLOYMENT_QUEUED
+		}
+	}
+
+	result, err := tx.Exec(deploymentReleaseStmt, string(state), d.Id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	id, err = result.LastInsertId()
+	affected, err := result.RowsAffected()
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	if affected == 0 {
+		tx.Rollback()
+		return ErrNotScheduled
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	d.State = state
+	return nil
+}
+
+func rescheduleDeployment(db *sql.DB, d *models.Deployment, scheduledAt time.Time) error {
+	scheduledAt = scheduledAt.UTC()
+
+	result, err := db.Exec(deploymentRescheduleStmt, scheduledAt, d.Id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotScheduled
+	}
+
+	d.ScheduledAt = &scheduledAt
+	return nil
+}
+
+func insertDeployment(tx *sql.Tx, d *models.Deployment, state models.DeploymentState) error {
+	createdAt := time.Now()
+
+	result, err := tx.Exec(deploymentInsertStmt, d.UserId, d.ApplicationName,
+		d.TargetName, d.CommitSha, d.Branch, d.Comment, string(state),
+		joinStages(d.Stages), d.FreezeOverride, d.StatusChecksOverride, d.ScheduledAt, d.PromotedFrom,
+		createdAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
 
 	d.Id = int(id)
 	d.State = state
 	d.CreatedAt = createdAt
 
-	return tx.Commit()
+	return nil
+}
+
+// saveDecision saves the decision of an approver about a deployment that
+// is pending approval. A rejection moves the deployment to state 'rejected'.
+// Once the deployment has the required number of approvals it is moved to
+// state 'queued'.
+func saveDecision(db *sql.DB, d *models.Deployment, a *models.Approval, requiredApprovals int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var state string
+	err = tx.QueryRow(deploymentStateStmt, d.Id).Scan(&state)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if models.DeploymentState(state) != models.DEPLOYMENT_PENDING_APPROVAL {
+		tx.Rollback()
+		return ErrNotPendingApproval
+	}
+
+	var decisions int
+	err = tx.QueryRow(approvalExistsStmt, d.Id, a.UserId).Scan(&decisions)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if decisions > 0 {
+		tx.Rollback()
+		return ErrAlreadyDecided
+	}
+
+	createdAt := time.Now()
+	result, err := tx.Exec(approvalInsertStmt, d.Id, a.UserId, a.Approved,
+		a.Comment, createdAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	newState := models.DEPLOYMENT_PENDING_APPROVAL
+	if !a.Approved {
+		newState = models.DEPLOYMENT_REJECTED
+	} else {
+		var approvals int
+		err = tx.QueryRow(approvalCountStmt, d.Id).Scan(&approvals)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if approvals >= requiredApprovals {
+			newState = models.DEPLOYMENT_QUEUED
+		}
+	}
+
+	if newState != models.DEPLOYMENT_PENDING_APPROVAL {
+		_, err = tx.Exec(deploymentDecideStmt, string(newState), d.Id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	a.Id = int(id)
+	a.DeploymentId = d.Id
+	a.CreatedAt = createdAt
+	d.State = newState
+
+	return nil
+}
+
+func createFreeze(db *sql.DB, f *models.Freeze) error {
+	createdAt := time.Now().UTC()
+	expiresAt := f.ExpiresAt.UTC()
+
+	result, err := db.Exec(freezeInsertStmt, f.ApplicationName, f.TargetName,
+		f.UserId, f.Reason, expiresAt, createdAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	f.Id = int(id)
+	f.ExpiresAt = expiresAt
+	f.CreatedAt = createdAt
+	return nil
+}
+
+func getFreeze(db *sql.DB, id int) (*models.Freeze, error) {
+	rows, err := db.Query(freezeStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	freezes, err := readFreezes(rows)
+	if err != nil || len(freezes) == 0 {
+		return nil, err
+	}
+
+	return freezes[0], nil
+}
+
+// getActiveFreezes returns the freezes of the application's targets that have
+// neither expired nor been lifted, those that last longest first.
+func getActiveFreezes(db *sql.DB, a *models.Application, now time.Time) ([]*models.Freeze, error) {
+	rows, err := db.Query(activeFreezesStmt, a.Name, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFreezes(rows)
+}
+
+func liftFreeze(db *sql.DB, f *models.Freeze, userName string) error {
+	_, err := db.Exec(freezeLiftStmt, userName, f.Id)
+	return err
+}
+
+func readFreezes(rows *sql.Rows) ([]*models.Freeze, error) {
+	freezes := []*models.Freeze{}
+
+	for rows.Next() {
+		f := &models.Freeze{User: &models.User{}}
+
+		err := rows.Scan(&f.Id, &f.ApplicationName, &f.TargetName, &f.UserId,
+			&f.Reason, &f.ExpiresAt, &f.CreatedAt, &f.User.Name, &f.User.AvatarUrl)
+		if err != nil {
+			return freezes, err
+		}
+		f.User.Id = f.UserId
+
+		freezes = append(freezes, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return freezes, err
+	}
+
+	return freezes, nil
+}
+
+func getDeploymentApprovals(db *sql.DB, d *models.Deployment) ([]*models.Approval, error) {
+	approvals := []*models.Approval{}
+
+	rows, err := db.Query(deploymentApprovalsStmt, d.Id)
+	if err != nil {
+		return approvals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := &models.Approval{User: &models.User{}}
+
+		err = rows.Scan(&a.Id, &a.DeploymentId, &a.UserId, &a.Approved,
+			&a.Comment, &a.CreatedAt, &a.User.Name, &a.User.AvatarUrl)
+		if err != nil {
+			return approvals, err
+		}
+		a.User.Id = a.UserId
+
+		approvals = append(approvals, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return approvals, err
+	}
+
+	return approvals, nil
+}
+
+// dequeueDeployment moves the oldest queued deployment of the target to state
+// 'new' and returns it. It returns nil if no deployment is queued or if
+// another deployment to the target is still running.
+func dequeueDeployment(db *sql.DB, applicationName, targetName string) (*models.Deployment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	running, err := activeDeploymentExists(tx, applicationName, targetName)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if running {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	var id int
+	err = tx.QueryRow(nextQueuedDeploymentStmt, applicationName, targetName).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		tx.Rollback()
+		return nil, nil
+	case err != nil:
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(deploymentDequeueStmt, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return getDeployment(db, id)
+}
+
+// cancelDeployment cancels a queued, scheduled or pending deployment. It
+// returns ErrNotWaiting if the deployment is not waiting anymore.
+func cancelDeployment(db *sql.DB, d *models.Deployment) error {
+	result, err := db.Exec(deploymentCancelStmt, d.Id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotWaiting
+	}
+
+	d.State = models.DEPLOYMENT_CANCELLED
+	d.QueuePosition = 0
+	return nil
+}
+
+// loadQueuePositions sets the QueuePosition of all queued deployments.
+func loadQueuePositions(db *sql.DB, a *models.Application, deployments []*models.Deployment) error {
+	for _, d := range deployments {
+		if d.State != models.DEPLOYMENT_QUEUED {
+			continue
+		}
+
+		err := db.QueryRow(queuePositionStmt, a.Name, d.TargetName, d.Id).Scan(&d.QueuePosition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func updateDeploymentState(db *sql.DB, d *models.Deployment, state models.DeploymentState) error {
@@ -85,6 +541,26 @@ func updateDeploymentState(db *sql.DB, d *models.Deployment, state models.Deploy
 	return nil
 }
 
+func updateDeploymentKilledBy(db *sql.DB, d *models.Deployment, killer string) error {
+	_, err := db.Exec(deploymentUpdateKilledByStmt, killer, d.Id)
+	if err != nil {
+		return err
+	}
+
+	d.KilledBy = killer
+	return nil
+}
+
+func updateDeploymentFailedHosts(db *sql.DB, d *models.Deployment, hosts []string) error {
+	_, err := db.Exec(deploymentUpdateFailedHostsStmt, strings.Join(hosts, ","), d.Id)
+	if err != nil {
+		return err
+	}
+
+	d.FailedHosts = hosts
+	return nil
+}
+
 func getRecentApplicationDeployments(db *sql.DB, a *models.Application) ([]*models.Deployment, error) {
 	return getApplicationDeployments(db, a, 10)
 }
@@ -117,15 +593,16 @@ func readApplicationDeployments(rows *sql.Rows) ([]*models.Deployment, error) {
 	deployments := []*models.Deployment{}
 
 	for rows.Next() {
-		var state string
+		var state, stages string
 		d := &models.Deployment{}
 
-		err := rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+		err := rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &stages, &d.CreatedAt)
 		if err != nil {
 			return deployments, err
 		}
 
 		d.State = models.DeploymentState(state)
+		d.Stages = splitStages(stages)
 
 		deployments = append(deployments, d)
 	}
@@ -141,9 +618,12 @@ func getDeployment(db *sql.DB, id int) (*models.Deployment, error) {
 	return queryDeploymentRow(db, deploymentStmt, id)
 }
 
+// getLastTargetDeployment returns the last successful or partially successful
+// deployment to the target, nil if there is none.
 func getLastTargetDeployment(db *sql.DB, a *models.Application, targetName string) (*models.Deployment, error) {
 	return queryDeploymentRow(db, lastTargetDeploymentStmt,
-		string(models.DEPLOYMENT_SUCCESSFUL), a.Name, targetName)
+		string(models.DEPLOYMENT_SUCCESSFUL),
+		string(models.DEPLOYMENT_PARTIALLY_SUCCESSFUL), a.Name, targetName)
 }
 
 func getDailyDigestDeployments(db *sql.DB, a *models.Application, targetName string, since time.Time) ([]*models.Deployment, error) {
@@ -156,15 +636,16 @@ func getDailyDigestDeployments(db *sql.DB, a *models.Application, targetName str
 	defer rows.Close()
 
 	for rows.Next() {
-		var state string
+		var state, stages string
 		d := &models.Deployment{}
 
-		err = rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+		err = rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &stages, &d.CreatedAt)
 		if err != nil {
 			return deployments, err
 		}
 
 		d.State = models.DeploymentState(state)
+		d.Stages = splitStages(stages)
 
 		deployments = append(deployments, d)
 	}
@@ -231,6 +712,62 @@ func getDeploymentLogEntries(db *sql.DB, d *models.Deployment) ([]*deploy.LogEnt
 	return entries, nil
 }
 
+func createDeploymentScripts(db *sql.DB, scripts []*models.DeploymentScript) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range scripts {
+		result, err := tx.Exec(deploymentScriptInsertStmt, s.DeploymentId,
+			s.Host, string(s.Stage), s.Script, time.Now())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		s.Id = int(id)
+	}
+
+	return tx.Commit()
+}
+
+func getDeploymentScripts(db *sql.DB, d *models.Deployment) ([]*models.DeploymentScript, error) {
+	scripts := []*models.DeploymentScript{}
+
+	rows, err := db.Query(deploymentScriptsStmt, d.Id)
+	if err != nil {
+		return scripts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stage string
+		s := &models.DeploymentScript{}
+
+		err = rows.Scan(&s.Id, &s.DeploymentId, &s.Host, &stage, &s.Script)
+		if err != nil {
+			return scripts, err
+		}
+
+		s.Stage = models.DeploymentStage(stage)
+
+		scripts = append(scripts, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return scripts, err
+	}
+
+	return scripts, nil
+}
+
 func newLogEntrySaver(db *sql.DB) deploy.Listener {
 	fn := func(logs <-chan deploy.LogEntry) {
 		for entry := range logs {
@@ -244,6 +781,116 @@ func newLogEntrySaver(db *sql.DB) deploy.Listener {
 	return fn
 }
 
+func getHostKey(db *sql.DB, host string) (string, error) {
+	var key string
+
+	err := db.QueryRow(hostKeyStmt, host).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func createHostKey(db *sql.DB, host, key string) error {
+	_, err := db.Exec(hostKeyInsertStmt, host, key, time.Now())
+	return err
+}
+
+// hostKeyStore implements deploy.HostKeyStore with the host_keys table
+type hostKeyStore struct {
+	db *sql.DB
+}
+
+func (s *hostKeyStore) GetHostKey(host string) (string, error) {
+	return getHostKey(s.db, host)
+}
+
+func (s *hostKeyStore) PinHostKey(host, key string) error {
+	return createHostKey(s.db, host, key)
+}
+
+// upsertSecret saves the encrypted value of the secret with the name, updating
+// the secret if it already exists.
+func upsertSecret(db *sql.DB, name, value string, userId int) error {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(secretUpdateStmt, value, userId, now, name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if updated == 0 {
+		_, err = tx.Exec(secretInsertStmt, name, value, userId, now, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getSecretValue returns the encrypted value of the secret with the name, an
+// empty string if there is no such secret.
+func getSecretValue(db *sql.DB, name string) (string, error) {
+	var value string
+
+	err := db.QueryRow(secretValueStmt, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return value, err
+}
+
+func deleteSecret(db *sql.DB, name string) error {
+	_, err := db.Exec(secretDeleteStmt, name)
+	return err
+}
+
+// getSecrets returns all secrets, without their values.
+func getSecrets(db *sql.DB) ([]*models.Secret, error) {
+	secrets := []*models.Secret{}
+
+	rows, err := db.Query(secretsStmt)
+	if err != nil {
+		return secrets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &models.Secret{User: &models.User{}}
+
+		err := rows.Scan(&s.Id, &s.Name, &s.UserId, &s.CreatedAt, &s.UpdatedAt,
+			&s.User.Name, &s.User.AvatarUrl)
+		if err != nil {
+			return secrets, err
+		}
+		s.User.Id = s.UserId
+
+		secrets = append(secrets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return secrets, err
+	}
+
+	return secrets, nil
+}
+
 func createUser(db *sql.DB, u *models.User) error {
 	u.ApiToken = uuid.New()
 	_, err := db.Exec(userInsertStmt, u.Id, u.Name, u.AccessToken, u.AvatarUrl, u.ApiToken)
@@ -266,6 +913,22 @@ func getUser(db *sql.DB, id int) (*models.User, error) {
 	return u, nil
 }
 
+// getUserByName returns the user with the given GitHub username, nil if the
+// user never logged in to Applikatoni.
+func getUserByName(db *sql.DB, name string) (*models.User, error) {
+	u := &models.User{}
+
+	err := db.QueryRow(userNameStmt, name).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
 func getUserByApiToken(db *sql.DB, token string) (*models.User, error) {
 	u := &models.User{}
 
@@ -375,12 +1038,45 @@ func activeDeploymentExists(tx *sql.Tx, applicationName, targetName string) (boo
 	}
 }
 
+// userDeployedCommit returns true if the user already created a deployment of
+// the commit to the target that is waiting, running or succeeded. Failed,
+// cancelled, rejected and killed deployments don't count.
+func userDeployedCommit(db *sql.DB, a *models.Application, targetName, commitSha string, u *models.User) (bool, error) {
+	var count int
+	err := db.QueryRow(userCommitDeploymentsStmt, a.Name, targetName, commitSha, u.Id).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func targetBusy(tx *sql.Tx, applicationName, targetName string) (bool, error) {
+	running, err := activeDeploymentExists(tx, applicationName, targetName)
+	if err != nil || running {
+		return running, err
+	}
+
+	var id int
+	err = tx.QueryRow(nextQueuedDeploymentStmt, applicationName, targetName).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
 func queryDeploymentRow(db *sql.DB, query string, args ...interface{}) (*models.Deployment, error) {
 	d := &models.Deployment{}
-	var state string
+	var state, stages, failedHosts string
 
 	err := db.QueryRow(query, args...).Scan(&d.Id, &d.UserId, &d.ApplicationName,
-		&d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+		&d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &stages,
+		&d.KilledBy, &d.FreezeOverride, &d.StatusChecksOverride, &d.ScheduledAt, &d.PromotedFrom,
+		&failedHosts, &d.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -388,10 +1084,34 @@ func queryDeploymentRow(db *sql.DB, query string, args ...interface{}) (*models.
 		return nil, err
 	}
 	d.State = models.DeploymentState(state)
+	d.Stages = splitStages(stages)
+	if failedHosts != "" {
+		d.FailedHosts = strings.Split(failedHosts, ",")
+	}
 
 	return d, nil
 }
 
+func joinStages(stages []models.DeploymentStage) string {
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitStages(joined string) []models.DeploymentStage {
+	stages := []models.DeploymentStage{}
+	if joined == "" {
+		return stages
+	}
+
+	for _, name := range strings.Split(joined, ",") {
+		stages = append(stages, models.DeploymentStage(name))
+	}
+	return stages
+}
+
 func isMigrated(db *sql.DB) (bool, error) {
 	dbconf, err := goose.NewDBConf(*dbConfDir, *env, "")
 	if err != nil {
@@ -17,6 +17,11 @@ var cleanStmts []string = []string{
 	"DELETE FROM deployments;",
 	"DELETE FROM log_entries;",
 	"DELETE FROM users;",
+	"DELETE FROM deployment_scripts;",
+	"DELETE FROM host_keys;",
+	"DELETE FROM approvals;",
+	"DELETE FROM freezes;",
+	"DELETE FROM secrets;",
 }
 
 func newTestDb(t *testing.T) *sql.DB {
@@ -71,6 +76,7 @@ func buildDeployment(userId int) *models.Deployment {
 		Comment:         "Deploying a hotfix",
 		ApplicationName: "flincOnRails",
 		TargetName:      "production",
+		Stages:          []models.DeploymentStage{"PRE_DEPLOYMENT", "CODE_DEPLOYMENT"},
 	}
 }
 
@@ -130,6 +136,32 @@ func TestUpdateDeploymentState(t *testing.T) {
 	}
 }
 
+func TestUpdateDeploymentFailedHosts(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := buildDeployment(9999)
+
+	err := createDeployment(db, deployment)
+	checkErr(t, err)
+
+	hosts := []string{"web1.applikatoni.com:22", "web2.applikatoni.com:22"}
+	err = updateDeploymentFailedHosts(db, deployment, hosts)
+	checkErr(t, err)
+
+	saved, err := getDeployment(db, deployment.Id)
+	checkErr(t, err)
+
+	if len(saved.FailedHosts) != len(hosts) {
+		t.Fatalf("wrong number of failed hosts. want=%d, got=%d", len(hosts), len(saved.FailedHosts))
+	}
+	for i, host := range hosts {
+		if saved.FailedHosts[i] != host {
+			t.Errorf("wrong failed host. want=%s, got=%s", host, saved.FailedHosts[i])
+		}
+	}
+}
+
 func TestGetApplicationDeployments(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -238,6 +270,38 @@ func TestGetDeployment(t *testing.T) {
 	if savedDeployment.CreatedAt.UTC() != deployment.CreatedAt.UTC() {
 		t.Errorf("wrong timestamp. got=%s want=%s", savedDeployment.CreatedAt.UTC(), deployment.CreatedAt.UTC())
 	}
+	if joinStages(savedDeployment.Stages) != joinStages(deployment.Stages) {
+		t.Errorf("wrong stages. got=%v want=%v", savedDeployment.Stages, deployment.Stages)
+	}
+}
+
+func TestJoinSplitStages(t *testing.T) {
+	tests := []struct {
+		stages []models.DeploymentStage
+		joined string
+	}{
+		{[]models.DeploymentStage{}, ""},
+		{[]models.DeploymentStage{"ONE"}, "ONE"},
+		{[]models.DeploymentStage{"ONE", "TWO", "THREE"}, "ONE,TWO,THREE"},
+	}
+
+	for _, tt := range tests {
+		joined := joinStages(tt.stages)
+		if joined != tt.joined {
+			t.Errorf("wrong joined stages. want=%q, got=%q", tt.joined, joined)
+		}
+
+		split := splitStages(joined)
+		if len(split) != len(tt.stages) {
+			t.Errorf("wrong number of split stages. want=%d, got=%d", len(tt.stages), len(split))
+			continue
+		}
+		for i := range split {
+			if split[i] != tt.stages[i] {
+				t.Errorf("wrong stage. want=%s, got=%s", tt.stages[i], split[i])
+			}
+		}
+	}
 }
 
 func TestGetLastTargetDeployment(t *testing.T) {
@@ -328,6 +392,18 @@ func TestGetLastTargetDeployment(t *testing.T) {
 			"last successful other target", last.Comment)
 	}
 
+	_, err = db.Exec(stmt, 9999, app.Name, target, "SH4BBB", "master",
+		"last partially successful", string(models.DEPLOYMENT_PARTIALLY_SUCCESSFUL),
+		time.Now().Add(-10*time.Minute))
+	checkErr(t, err)
+
+	last, err = getLastTargetDeployment(db, app, target)
+	checkErr(t, err)
+	if last == nil || last.Comment != "last partially successful" {
+		t.Errorf("wrong last deployment. expected=%s, got=%+v",
+			"last partially successful", last)
+	}
+
 	last, err = getLastTargetDeployment(db, app, "does not exist")
 	if err != nil {
 		t.Error(err)
@@ -415,6 +491,55 @@ func TestGetDeploymentLogEntries(t *testing.T) {
 	}
 }
 
+func TestGetDeploymentScripts(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := &models.Deployment{Id: 99}
+	scripts := []*models.DeploymentScript{
+		{
+			DeploymentId: deployment.Id,
+			Host:         "production.server.com",
+			Stage:        "MIGRATE_DATABASE",
+			Script:       "bundle exec rake db:migrate",
+		},
+		{
+			DeploymentId: deployment.Id,
+			Host:         "production.server.com",
+			Stage:        "DEPLOY",
+			Script:       "git checkout ****",
+		},
+	}
+	err := createDeploymentScripts(db, scripts)
+	checkErr(t, err)
+
+	otherScript := &models.DeploymentScript{DeploymentId: 100, Host: "other.server.com"}
+	err = createDeploymentScripts(db, []*models.DeploymentScript{otherScript})
+	checkErr(t, err)
+
+	saved, err := getDeploymentScripts(db, deployment)
+	checkErr(t, err)
+
+	if len(saved) != len(scripts) {
+		t.Fatalf("wrong length of scripts. want=%d, got=%d", len(scripts), len(saved))
+	}
+
+	for i, s := range saved {
+		if s.Id != scripts[i].Id {
+			t.Errorf("wrong order of scripts. want=%d, got=%d", scripts[i].Id, s.Id)
+		}
+		if s.Host != scripts[i].Host {
+			t.Errorf("wrong host saved. want=%s, got=%s", scripts[i].Host, s.Host)
+		}
+		if s.Stage != scripts[i].Stage {
+			t.Errorf("wrong stage saved. want=%s, got=%s", scripts[i].Stage, s.Stage)
+		}
+		if s.Script != scripts[i].Script {
+			t.Errorf("wrong script saved. want=%s, got=%s", scripts[i].Script, s.Script)
+		}
+	}
+}
+
 func TestNewLogEntrySaver(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -617,21 +742,332 @@ func TestCreateDeploymentWithActiveDeployments(t *testing.T) {
 	err = updateDeploymentState(db, deployment, models.DEPLOYMENT_ACTIVE)
 	checkErr(t, err)
 
-	// Try to create a new deployment for this application
+	// Create a new deployment for this application
 	newDeployment := buildDeployment(9999)
 	newDeployment.ApplicationName = "application_one"
 	err = createDeployment(db, newDeployment)
-	if err != ErrDeployInProgress {
-		t.Errorf("createDeployment didnt fail with correct error: %s", err)
+	checkErr(t, err)
+	if newDeployment.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, newDeployment.State)
 	}
 
-	// Try to create a new deployment for another application
+	// Once the active deployment finished, new deployments still queue up
+	// behind the queued one
+	err = updateDeploymentState(db, deployment, models.DEPLOYMENT_SUCCESSFUL)
+	checkErr(t, err)
+
+	newDeployment = buildDeployment(9999)
+	newDeployment.ApplicationName = "application_one"
+	err = createDeployment(db, newDeployment)
+	checkErr(t, err)
+	if newDeployment.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, newDeployment.State)
+	}
+
+	// Create a new deployment for another application
 	newDeployment = buildDeployment(9999)
 	newDeployment.ApplicationName = "application_two"
 	err = createDeployment(db, newDeployment)
 	if err != nil {
 		t.Errorf("createDeployment failed error: %s", err)
 	}
+	if newDeployment.State != models.DEPLOYMENT_NEW {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_NEW, newDeployment.State)
+	}
+}
+
+func TestDequeueDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	running := buildDeployment(9999)
+	err := createDeployment(db, running)
+	checkErr(t, err)
+
+	first := buildDeployment(9999)
+	err = createDeployment(db, first)
+	checkErr(t, err)
+	second := buildDeployment(9999)
+	err = createDeployment(db, second)
+	checkErr(t, err)
+
+	// Nothing is dequeued while a deployment is running
+	d, err := dequeueDeployment(db, running.ApplicationName, running.TargetName)
+	checkErr(t, err)
+	if d != nil {
+		t.Fatalf("deployment %d dequeued while target is busy", d.Id)
+	}
+
+	err = updateDeploymentState(db, running, models.DEPLOYMENT_SUCCESSFUL)
+	checkErr(t, err)
+
+	d, err = dequeueDeployment(db, running.ApplicationName, running.TargetName)
+	checkErr(t, err)
+	if d == nil || d.Id != first.Id {
+		t.Fatalf("wrong deployment dequeued. want=%d, got=%v", first.Id, d)
+	}
+	if d.State != models.DEPLOYMENT_NEW {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_NEW, d.State)
+	}
+
+	// The dequeued deployment now occupies the target
+	d, err = dequeueDeployment(db, running.ApplicationName, running.TargetName)
+	checkErr(t, err)
+	if d != nil {
+		t.Errorf("deployment %d dequeued while target is busy", d.Id)
+	}
+
+	err = updateDeploymentState(db, first, models.DEPLOYMENT_FAILED)
+	checkErr(t, err)
+	err = cancelDeployment(db, second)
+	checkErr(t, err)
+
+	d, err = dequeueDeployment(db, running.ApplicationName, running.TargetName)
+	checkErr(t, err)
+	if d != nil {
+		t.Errorf("cancelled deployment %d dequeued", d.Id)
+	}
+}
+
+func TestCancelQueuedDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	running := buildDeployment(9999)
+	err := createDeployment(db, running)
+	checkErr(t, err)
+	queued := buildDeployment(9999)
+	err = createDeployment(db, queued)
+	checkErr(t, err)
+
+	err = cancelDeployment(db, running)
+	if err != ErrNotWaiting {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNotWaiting, err)
+	}
+
+	err = cancelDeployment(db, queued)
+	checkErr(t, err)
+
+	cancelled, err := getDeployment(db, queued.Id)
+	checkErr(t, err)
+	if cancelled.State != models.DEPLOYMENT_CANCELLED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_CANCELLED, cancelled.State)
+	}
+
+	err = cancelDeployment(db, queued)
+	if err != ErrNotWaiting {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNotWaiting, err)
+	}
+}
+
+func TestCancelPendingDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	pending := buildDeployment(9999)
+	err := createPendingDeployment(db, pending)
+	checkErr(t, err)
+
+	err = cancelDeployment(db, pending)
+	checkErr(t, err)
+
+	cancelled, err := getDeployment(db, pending.Id)
+	checkErr(t, err)
+	if cancelled.State != models.DEPLOYMENT_CANCELLED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_CANCELLED, cancelled.State)
+	}
+}
+
+func TestLoadQueuePositions(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	a := &models.Application{Name: "flincOnRails"}
+
+	deployments := []*models.Deployment{}
+	for i := 0; i < 4; i++ {
+		d := buildDeployment(9999)
+		err := createDeployment(db, d)
+		checkErr(t, err)
+		deployments = append(deployments, d)
+	}
+	staging := buildDeployment(9999)
+	staging.TargetName = "staging"
+	err := createDeployment(db, staging)
+	checkErr(t, err)
+	deployments = append(deployments, staging)
+
+	err = cancelDeployment(db, deployments[1])
+	checkErr(t, err)
+
+	err = loadQueuePositions(db, a, deployments)
+	checkErr(t, err)
+
+	expected := []int{0, 0, 1, 2, 0}
+	for i, d := range deployments {
+		if d.QueuePosition != expected[i] {
+			t.Errorf("wrong queue position of deployment %d. want=%d, got=%d", i, expected[i], d.QueuePosition)
+		}
+	}
+}
+
+func TestSaveDecision(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := buildDeployment(9999)
+	err := createPendingDeployment(db, deployment)
+	checkErr(t, err)
+	if deployment.State != models.DEPLOYMENT_PENDING_APPROVAL {
+		t.Fatalf("wrong state. want=%s, got=%s", models.DEPLOYMENT_PENDING_APPROVAL, deployment.State)
+	}
+
+	tests := []struct {
+		userId        int
+		approved      bool
+		expectedErr   error
+		expectedState models.DeploymentState
+	}{
+		{1, true, nil, models.DEPLOYMENT_PENDING_APPROVAL},
+		{1, true, ErrAlreadyDecided, models.DEPLOYMENT_PENDING_APPROVAL},
+		{2, true, nil, models.DEPLOYMENT_QUEUED},
+		{3, true, ErrNotPendingApproval, models.DEPLOYMENT_QUEUED},
+	}
+
+	for i, tt := range tests {
+		approval := &models.Approval{UserId: tt.userId, Approved: tt.approved}
+
+		err := saveDecision(db, deployment, approval, 2)
+		if err != tt.expectedErr {
+			t.Errorf("%d: wrong error. want=%v, got=%v", i, tt.expectedErr, err)
+		}
+
+		saved, err := getDeployment(db, deployment.Id)
+		checkErr(t, err)
+		if saved.State != tt.expectedState {
+			t.Errorf("%d: wrong state. want=%s, got=%s", i, tt.expectedState, saved.State)
+		}
+	}
+}
+
+func TestSaveDecisionRejection(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := &models.User{Id: 1, Name: "approver", AvatarUrl: "http://example.com/a.png"}
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	deployment := buildDeployment(9999)
+	err = createPendingDeployment(db, deployment)
+	checkErr(t, err)
+
+	approval := &models.Approval{UserId: user.Id, Approved: false, Comment: "not on a friday"}
+	err = saveDecision(db, deployment, approval, 1)
+	checkErr(t, err)
+	if deployment.State != models.DEPLOYMENT_REJECTED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_REJECTED, deployment.State)
+	}
+
+	approvals, err := getDeploymentApprovals(db, deployment)
+	checkErr(t, err)
+	if len(approvals) != 1 {
+		t.Fatalf("wrong number of approvals. want=%d, got=%d", 1, len(approvals))
+	}
+	if approvals[0].Approved || approvals[0].Comment != approval.Comment {
+		t.Errorf("wrong approval saved: %+v", approvals[0])
+	}
+	if approvals[0].User.Name != user.Name {
+		t.Errorf("wrong approver. want=%s, got=%s", user.Name, approvals[0].User.Name)
+	}
+}
+
+func TestScheduledDeployments(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	now := time.Now()
+
+	due := buildDeployment(9999)
+	dueAt := now.Add(-time.Minute)
+	due.ScheduledAt = &dueAt
+	err := createScheduledDeployment(db, due)
+	checkErr(t, err)
+
+	later := buildDeployment(9999)
+	laterAt := now.Add(time.Hour)
+	later.ScheduledAt = &laterAt
+	err = createScheduledDeployment(db, later)
+	checkErr(t, err)
+
+	deployments, err := getDueScheduledDeployments(db, now)
+	checkErr(t, err)
+	if len(deployments) != 1 || deployments[0].Id != due.Id {
+		t.Fatalf("wrong due deployments. want=[%d], got=%v", due.Id, deployments)
+	}
+	if deployments[0].ScheduledAt == nil || !deployments[0].ScheduledAt.Equal(dueAt) {
+		t.Errorf("wrong scheduled time. want=%s, got=%v", dueAt, deployments[0].ScheduledAt)
+	}
+
+	err = releaseScheduledDeployment(db, due, false)
+	checkErr(t, err)
+	if due.State != models.DEPLOYMENT_NEW {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_NEW, due.State)
+	}
+
+	err = releaseScheduledDeployment(db, due, false)
+	if err != ErrNotScheduled {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNotScheduled, err)
+	}
+
+	// The target is busy now
+	err = rescheduleDeployment(db, later, now.Add(-time.Second))
+	checkErr(t, err)
+
+	deployments, err = getDueScheduledDeployments(db, now)
+	checkErr(t, err)
+	if len(deployments) != 1 || deployments[0].Id != later.Id {
+		t.Fatalf("wrong due deployments. want=[%d], got=%v", later.Id, deployments)
+	}
+
+	err = releaseScheduledDeployment(db, later, false)
+	checkErr(t, err)
+	if later.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, later.State)
+	}
+
+	approved := buildDeployment(9999)
+	approved.ScheduledAt = &dueAt
+	err = createScheduledDeployment(db, approved)
+	checkErr(t, err)
+	err = releaseScheduledDeployment(db, approved, true)
+	checkErr(t, err)
+	if approved.State != models.DEPLOYMENT_PENDING_APPROVAL {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_PENDING_APPROVAL, approved.State)
+	}
+}
+
+func TestCancelScheduledDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	scheduledAt := time.Now().Add(time.Hour)
+	d := buildDeployment(9999)
+	d.ScheduledAt = &scheduledAt
+	err := createScheduledDeployment(db, d)
+	checkErr(t, err)
+
+	err = cancelDeployment(db, d)
+	checkErr(t, err)
+
+	err = rescheduleDeployment(db, d, scheduledAt)
+	if err != ErrNotScheduled {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNotScheduled, err)
+	}
+	err = releaseScheduledDeployment(db, d, false)
+	if err != ErrNotScheduled {
+		t.Errorf("wrong error. want=%s, got=%v", ErrNotScheduled, err)
+	}
 }
 
 func TestGetDailyDigestDeployments(t *testing.T) {
@@ -656,6 +1092,7 @@ func TestGetDailyDigestDeployments(t *testing.T) {
 	}{
 		// should be included
 		{"awesomeDB", "production", time.Now().Add(-12 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		{"awesomeDB", "production", time.Now().Add(-11 * time.Hour), models.DEPLOYMENT_PARTIALLY_SUCCESSFUL},
 		// these should not be included
 		{"awesomeDB", "production", time.Now().Add(-45 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
 		{"awesomeDB", "staging", time.Now().Add(-10 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
@@ -671,7 +1108,7 @@ func TestGetDailyDigestDeployments(t *testing.T) {
 	deployments, err := getDailyDigestDeployments(db, a, targetName, since)
 	checkErr(t, err)
 
-	if len(deployments) != 1 {
+	if len(deployments) != 2 {
 		t.Errorf("getDailyDigestDeployments wrong number of deployments: %d", len(deployments))
 	}
 }
@@ -715,3 +1152,30 @@ func TestFailUnfinishedDeployments(t *testing.T) {
 		t.Errorf("wrong count of successful deployments. want=%d, got=%d", 1, count)
 	}
 }
+
+func TestGetAndCreateHostKey(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	store := &hostKeyStore{db}
+
+	key, err := store.GetHostKey("web.applikatoni.com")
+	checkErr(t, err)
+	if key != "" {
+		t.Errorf("expected no pinned key. got=%s", key)
+	}
+
+	err = store.PinHostKey("web.applikatoni.com", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5")
+	checkErr(t, err)
+
+	key, err = store.GetHostKey("web.applikatoni.com")
+	checkErr(t, err)
+	if key != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5" {
+		t.Errorf("wrong pinned key. want=%s, got=%s", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5", key)
+	}
+
+	err = store.PinHostKey("web.applikatoni.com", "ssh-ed25519 OTHER")
+	if err == nil {
+		t.Errorf("expected pinning a second key for the same host to fail")
+	}
+}
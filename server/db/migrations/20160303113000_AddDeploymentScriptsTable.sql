@@ -0,0 +1,18 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE deployment_scripts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  deployment_id INTEGER,
+  host TEXT,
+  stage TEXT,
+  script TEXT,
+  created_at DATETIME
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE deployment_scripts;
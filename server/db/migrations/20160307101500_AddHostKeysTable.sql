@@ -0,0 +1,16 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE host_keys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  host TEXT NOT NULL UNIQUE,
+  public_key TEXT NOT NULL,
+  created_at DATETIME
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE host_keys;
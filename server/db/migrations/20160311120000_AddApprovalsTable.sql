@@ -0,0 +1,19 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE approvals (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  deployment_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  approved BOOLEAN NOT NULL,
+  comment TEXT NOT NULL DEFAULT "",
+  created_at DATETIME,
+  UNIQUE (deployment_id, user_id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE approvals;
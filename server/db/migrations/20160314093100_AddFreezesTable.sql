@@ -0,0 +1,20 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE freezes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  application_name TEXT NOT NULL,
+  target_name TEXT NOT NULL,
+  user_id INTEGER NOT NULL,
+  reason TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  lifted_by TEXT NOT NULL DEFAULT "",
+  created_at DATETIME
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE freezes;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE deployments ADD COLUMN scheduled_at DATETIME;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- No Down migration here, since sqlite doesnt allow removing columns
+SELECT 1;
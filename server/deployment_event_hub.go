@@ -14,6 +14,7 @@ type DeploymentEvent struct {
 	Application *models.Application
 	Target      *models.Target
 	User        *models.User
+	Approval    *models.Approval
 }
 
 func (de *DeploymentEvent) DeploymentURL() string {
@@ -45,6 +46,14 @@ func NewDeploymentEventHub(db *sql.DB) *DeploymentEventHub {
 	hub.Subscribers[models.DEPLOYMENT_ACTIVE] = []Subscriber{}
 	hub.Subscribers[models.DEPLOYMENT_SUCCESSFUL] = []Subscriber{}
 	hub.Subscribers[models.DEPLOYMENT_FAILED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_ROLLED_BACK] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_KILLED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_QUEUED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_CANCELLED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_PENDING_APPROVAL] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_REJECTED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_SCHEDULED] = []Subscriber{}
+	hub.Subscribers[models.DEPLOYMENT_PARTIALLY_SUCCESSFUL] = []Subscriber{}
 
 	return hub
 }
@@ -56,6 +65,16 @@ func (hub *DeploymentEventHub) Subscribe(states []models.DeploymentState, s Subs
 }
 
 func (hub *DeploymentEventHub) Publish(state models.DeploymentState, d *models.Deployment) {
+	hub.publish(state, d, nil)
+}
+
+// PublishApproval publishes the decision of an approver with the state the
+// deployment is in after the decision.
+func (hub *DeploymentEventHub) PublishApproval(d *models.Deployment, a *models.Approval) {
+	hub.publish(d.State, d, a)
+}
+
+func (hub *DeploymentEventHub) publish(state models.DeploymentState, d *models.Deployment, a *models.Approval) {
 	subscribers := hub.Subscribers[state]
 	if len(subscribers) == 0 {
 		return
@@ -67,6 +86,7 @@ func (hub *DeploymentEventHub) Publish(state models.DeploymentState, d *models.D
 			err)
 		return
 	}
+	event.Approval = a
 
 	for _, subscriber := range subscribers {
 		go subscriber(event)
@@ -94,7 +114,7 @@ func (hub *DeploymentEventHub) buildDeploymentEvent(s models.DeploymentState, d
 		State:       s,
 		Deployment:  d,
 		Application: application,
-		Target:      target,
+		Target:      resolveTargetSecrets(target),
 		User:        user,
 	}
 
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// startDeployment saves the deployment to the database and runs it in the
+// background with a deploy.Manager. If another deployment to the target is
+// running, the deployment is queued and started once its turn has come. If
+// the target requires approvals, the deployment waits for them before it is
+// queued. Deployments with a ScheduledAt time are only saved and started by
+// the scheduler once they are due. It returns as soon as the deployment has
+// been started, queued or saved.
+func startDeployment(a *models.Application, t *models.Target, d *models.Deployment) error {
+	var err error
+	switch {
+	case d.ScheduledAt != nil:
+		err = createScheduledDeployment(db, d)
+	case t.NeedsApproval():
+		err = createPendingDeployment(db, d)
+	default:
+		err = createDeployment(db, d)
+	}
+	if err != nil {
+		return err
+	}
+
+	return launchDeployment(a, t, d)
+}
+
+// startScheduledDeployment starts a scheduled deployment that is due, as if it
+// had just been created.
+func startScheduledDeployment(a *models.Application, t *models.Target, d *models.Deployment) error {
+	err := releaseScheduledDeployment(db, d, t.NeedsApproval())
+	if err != nil {
+		return err
+	}
+
+	return launchDeployment(a, t, d)
+}
+
+// launchDeployment announces a saved deployment and runs it if it doesn't
+// have to wait.
+func launchDeployment(a *models.Application, t *models.Target, d *models.Deployment) error {
+	eventHub.Publish(d.State, d)
+
+	if d.State != models.DEPLOYMENT_NEW {
+		return nil
+	}
+
+	err := runDeployment(a, t, d)
+	if err != nil {
+		go startNextDeployment(a, t)
+	}
+	return err
+}
+
+// decideDeployment records the approval or rejection of a deployment that is
+// pending approval and starts it if it has been approved often enough and the
+// target is not busy.
+func decideDeployment(a *models.Application, t *models.Target, d *models.Deployment, approval *models.Approval) error {
+	err := saveDecision(db, d, approval, t.RequiredApprovals)
+	if err != nil {
+		return err
+	}
+
+	eventHub.PublishApproval(d, approval)
+	if d.State == models.DEPLOYMENT_QUEUED {
+		startNextDeployment(a, t)
+	}
+
+	return nil
+}
+
+// startNextDeployment starts the oldest queued deployment to the target, if
+// there is one and the target is not busy. Since the target might have been
+// frozen while the deployment waited, the freezes are checked again, unless
+// the deployment overrode a freeze. Deployments to a frozen target are
+// cancelled.
+func startNextDeployment(a *models.Application, t *models.Target) {
+	for {
+		d, err := dequeueDeployment(db, a.Name, t.Name)
+		if err != nil {
+			log.Printf("Could not dequeue deployment to %s: %s\n", t.Name, err)
+			return
+		}
+		if d == nil {
+			return
+		}
+
+		if d.FreezeOverride == "" {
+			_, err = checkFreeze(a, t, nil, false, time.Now())
+			if err != nil {
+				if _, ok := err.(*FrozenError); ok {
+					log.Printf("Cancelling queued deployment %d: %s\n", d.Id, err)
+					finishDeployment(d, models.DEPLOYMENT_CANCELLED)
+				} else {
+					log.Printf("Checking queued deployment %d failed: %s\n", d.Id, err)
+					finishDeployment(d, models.DEPLOYMENT_FAILED)
+				}
+				continue
+			}
+		}
+
+		eventHub.Publish(d.State, d)
+
+		err = runDeployment(a, t, d)
+		if err == nil {
+			return
+		}
+		log.Printf("Could not start queued deployment %d: %s\n", d.Id, err)
+	}
+}
+
+// startQueuedDeployments starts the next queued deployment of every
+// configured target. It is used when booting up.
+func startQueuedDeployments(applications []*models.Application) {
+	for _, a := range applications {
+		for _, t := range a.Targets {
+			startNextDeployment(a, t)
+		}
+	}
+}
+
+// runDeployment runs a deployment in state 'new' in the background.
+func runDeployment(a *models.Application, t *models.Target, d *models.Deployment) error {
+	lastDeployment, err := getLastTargetDeployment(db, a, t.Name)
+	if err != nil {
+		finishDeployment(d, models.DEPLOYMENT_FAILED)
+		return err
+	}
+
+	killChan := killRegistry.Add(d.Id)
+
+	deploymentConfig := models.NewDeploymentConfig(d, t, d.Stages)
+	if lastDeployment != nil {
+		deploymentConfig.PreviousCommitSha = lastDeployment.CommitSha
+	}
+
+	err = resolveDeploymentSecrets(deploymentConfig)
+	if err != nil {
+		killRegistry.Remove(d.Id)
+		finishDeployment(d, models.DEPLOYMENT_FAILED)
+		return err
+	}
+
+	manager, err := deploy.NewManager(deploymentConfig, logRouter, killChan, &hostKeyStore{db})
+	if err != nil {
+		killRegistry.Remove(d.Id)
+		finishDeployment(d, models.DEPLOYMENT_FAILED)
+		return err
+	}
+
+	err = createDeploymentScripts(db, manager.Scripts())
+	if err != nil {
+		log.Printf("Could not save scripts of deployment %d: %s\n", d.Id, err)
+	}
+
+	manager.AnnounceStart()
+
+	err = updateDeploymentState(db, d, models.DEPLOYMENT_ACTIVE)
+	if err != nil {
+		killRegistry.Remove(d.Id)
+		finishDeployment(d, models.DEPLOYMENT_FAILED)
+		return err
+	}
+	eventHub.Publish(models.DEPLOYMENT_ACTIVE, d)
+
+	go func() {
+		newState := models.DEPLOYMENT_SUCCESSFUL
+		err := manager.Start()
+		if err != nil {
+			newState = models.DEPLOYMENT_FAILED
+			if manager.RolledBack() {
+				newState = models.DEPLOYMENT_ROLLED_BACK
+			}
+		}
+		if failedHosts := manager.FailedHosts(); err == nil && len(failedHosts) > 0 {
+			newState = models.DEPLOYMENT_PARTIALLY_SUCCESSFUL
+
+			err = updateDeploymentFailedHosts(db, d, failedHosts)
+			if err != nil {
+				log.Println("Could not save the failed hosts of the deployment", err)
+			}
+		}
+		if manager.Killed() {
+			newState = models.DEPLOYMENT_KILLED
+
+			err = updateDeploymentKilledBy(db, d, manager.KilledBy())
+			if err != nil {
+				log.Println("Could not save who killed the deployment", err)
+			}
+		}
+
+		finishDeployment(d, newState)
+		killRegistry.Remove(d.Id)
+
+		startNextDeployment(a, t)
+	}()
+
+	return nil
+}
+
+func finishDeployment(d *models.Deployment, state models.DeploymentState) {
+	err := updateDeploymentState(db, d, state)
+	if err != nil {
+		log.Println("Could not update deployment state")
+		return
+	}
+
+	eventHub.Publish(state, d)
+}
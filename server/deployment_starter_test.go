@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestStartDeploymentPublishesState(t *testing.T) {
+	db = newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	target := &models.Target{Name: "production"}
+	application := &models.Application{Name: "flincOnRails", Targets: []*models.Target{target}}
+	config = &Configuration{Applications: []*models.Application{application}}
+
+	active := buildDeployment(user.Id)
+	err = createDeployment(db, active)
+	checkErr(t, err)
+	err = updateDeploymentState(db, active, models.DEPLOYMENT_ACTIVE)
+	checkErr(t, err)
+
+	published := make(chan models.DeploymentState, 2)
+	eventHub = NewDeploymentEventHub(db)
+	eventHub.Subscribe([]models.DeploymentState{models.DEPLOYMENT_QUEUED, models.DEPLOYMENT_SCHEDULED},
+		func(ev *DeploymentEvent) { published <- ev.State })
+
+	queued := buildDeployment(user.Id)
+	err = startDeployment(application, target, queued)
+	checkErr(t, err)
+
+	scheduledAt := time.Now().Add(time.Hour)
+	scheduled := buildDeployment(user.Id)
+	scheduled.ScheduledAt = &scheduledAt
+	err = startDeployment(application, target, scheduled)
+	checkErr(t, err)
+
+	states := map[models.DeploymentState]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case state := <-published:
+			states[state] = true
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber not called. got=%v", states)
+		}
+	}
+
+	if !states[models.DEPLOYMENT_QUEUED] || !states[models.DEPLOYMENT_SCHEDULED] {
+		t.Errorf("wrong states published. want=queued and scheduled, got=%v", states)
+	}
+}
+
+func TestStartNextDeploymentChecksFreezes(t *testing.T) {
+	db = newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	target := &models.Target{Name: "production"}
+	application := &models.Application{Name: "flincOnRails", Targets: []*models.Target{target}}
+	config = &Configuration{Applications: []*models.Application{application}}
+	eventHub = NewDeploymentEventHub(db)
+
+	active := buildDeployment(user.Id)
+	err = createDeployment(db, active)
+	checkErr(t, err)
+	err = updateDeploymentState(db, active, models.DEPLOYMENT_ACTIVE)
+	checkErr(t, err)
+
+	queued := buildDeployment(user.Id)
+	err = startDeployment(application, target, queued)
+	checkErr(t, err)
+	if queued.State != models.DEPLOYMENT_QUEUED {
+		t.Fatalf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, queued.State)
+	}
+
+	// The target is frozen while the deployment waits
+	freeze := &models.Freeze{
+		ApplicationName: application.Name,
+		TargetName:      target.Name,
+		UserId:          user.Id,
+		Reason:          "incident",
+		ExpiresAt:       time.Now().Add(time.Hour),
+	}
+	err = createFreeze(db, freeze)
+	checkErr(t, err)
+
+	err = updateDeploymentState(db, active, models.DEPLOYMENT_SUCCESSFUL)
+	checkErr(t, err)
+
+	startNextDeployment(application, target)
+
+	queued, err = getDeployment(db, queued.Id)
+	checkErr(t, err)
+	if queued.State != models.DEPLOYMENT_CANCELLED {
+		t.Errorf("wrong state of frozen deployment. want=%s, got=%s", models.DEPLOYMENT_CANCELLED, queued.State)
+	}
+}
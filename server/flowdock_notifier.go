@@ -9,8 +9,9 @@ import (
 	"github.com/applikatoni/applikatoni/models"
 )
 
-const flowdockTmplStr = `{{.GitHubRepo}} {{if .Success}}Successfully Deployed{{else}}Deploy Failed{{end}}:
-**{{.Username}}** deployed **{{.Branch}}** on **{{.Target}}** :pizza:
+const flowdockTmplStr = `{{.GitHubRepo}} {{if .Success}}Successfully Deployed{{else if .PartiallySuccessful}}Partially Deployed{{else if .RolledBack}}Deploy Failed and Rolled Back{{else if .Killed}}Deploy Killed{{else}}Deploy Failed{{end}}:
+**{{.Username}}** deployed **{{.Branch}}** on **{{.Target}}** :pizza:{{if .FailedHosts}}
+**Failed hosts:** {{.FailedHosts}}{{end}}
 
 {{range $idx, $line := .CommentLines}}
 > {{$line}}
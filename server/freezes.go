@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// FrozenError is returned when a deployment is created while its target is
+// frozen.
+type FrozenError struct {
+	Freeze *models.Freeze
+}
+
+func (e *FrozenError) Error() string {
+	return fmt.Sprintf("deployments to %s are frozen until %s: %s",
+		e.Freeze.TargetName, e.Freeze.ExpiresAt.Format(time.RFC1123),
+		e.Freeze.Reason)
+}
+
+// activeFreezes returns the freezes of the application's targets that are
+// active at the given time: the freeze windows from the configuration and the
+// freezes created by users.
+func activeFreezes(a *models.Application, now time.Time) ([]*models.Freeze, error) {
+	freezes := []*models.Freeze{}
+
+	for _, t := range a.Targets {
+		for _, w := range t.FreezeWindows {
+			end, active, err := w.Until(now)
+			if err != nil {
+				return nil, err
+			}
+			if !active {
+				continue
+			}
+
+			freezes = append(freezes, &models.Freeze{
+				ApplicationName: a.Name,
+				TargetName:      t.Name,
+				Reason:          w.Reason,
+				ExpiresAt:       end,
+			})
+		}
+	}
+
+	userFreezes, err := getActiveFreezes(db, a, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(freezes, userFreezes...), nil
+}
+
+// checkFreeze returns a *FrozenError if the target is frozen at the given
+// time, unless the user wants to and is allowed to override the freeze. In
+// that case the reason of the overridden freeze is returned, so it can be
+// saved with the deployment.
+func checkFreeze(a *models.Application, t *models.Target, u *models.User, override bool, at time.Time) (string, error) {
+	freezes, err := activeFreezes(a, at)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range freezes {
+		if f.TargetName != t.Name {
+			continue
+		}
+
+		if override && t.CanOverrideFreeze(u.Name) {
+			return f.Reason, nil
+		}
+		return "", &FrozenError{Freeze: f}
+	}
+
+	return "", nil
+}
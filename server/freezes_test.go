@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestCheckFreeze(t *testing.T) {
+	db = newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+	overrider := buildUser(2, "alice")
+
+	now := time.Now().UTC()
+	production := &models.Target{
+		Name:                    "production",
+		FreezeOverrideUsernames: []string{"alice"},
+		FreezeWindows: []*models.FreezeWindow{
+			{
+				Start:  now.Add(-time.Hour).Format("15:04"),
+				End:    now.Add(time.Hour).Format("15:04"),
+				Reason: "lunch",
+			},
+		},
+	}
+	staging := &models.Target{Name: "staging", FreezeOverrideUsernames: []string{"alice"}}
+	sandbox := &models.Target{Name: "sandbox"}
+	application := &models.Application{
+		Name:    "flincOnRails",
+		Targets: []*models.Target{production, staging, sandbox},
+	}
+
+	freeze := &models.Freeze{
+		ApplicationName: application.Name,
+		TargetName:      staging.Name,
+		UserId:          user.Id,
+		Reason:          "incident",
+		ExpiresAt:       now.Add(time.Hour),
+	}
+	err = createFreeze(db, freeze)
+	checkErr(t, err)
+
+	tests := []struct {
+		target         *models.Target
+		user           *models.User
+		override       bool
+		expectedReason string
+		frozen         bool
+	}{
+		{production, user, false, "", true},
+		{production, user, true, "", true},
+		{production, overrider, false, "", true},
+		{production, overrider, true, "lunch", false},
+		{staging, user, false, "", true},
+		{staging, overrider, true, "incident", false},
+		{sandbox, user, false, "", false},
+	}
+
+	for i, tt := range tests {
+		reason, err := checkFreeze(application, tt.target, tt.user, tt.override, time.Now())
+		_, frozen := err.(*FrozenError)
+		if frozen != tt.frozen {
+			t.Errorf("%d: wrong result. want frozen=%t, got=%v", i, tt.frozen, err)
+		}
+		if reason != tt.expectedReason {
+			t.Errorf("%d: wrong override reason. want=%q, got=%q", i, tt.expectedReason, reason)
+		}
+	}
+
+	err = liftFreeze(db, freeze, overrider.Name)
+	checkErr(t, err)
+
+	_, err = checkFreeze(application, staging, user, false, time.Now())
+	if err != nil {
+		t.Errorf("staging still frozen after lifting the freeze: %s", err)
+	}
+}
+
+func TestGetActiveFreezes(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	application := &models.Application{Name: "flincOnRails"}
+	now := time.Now()
+
+	expiries := []time.Time{now.Add(time.Hour), now.Add(-time.Hour), now.Add(2 * time.Hour)}
+	for _, expiresAt := range expiries {
+		f := &models.Freeze{
+			ApplicationName: application.Name,
+			TargetName:      "production",
+			UserId:          user.Id,
+			Reason:          "incident",
+			ExpiresAt:       expiresAt,
+		}
+		err = createFreeze(db, f)
+		checkErr(t, err)
+	}
+
+	freezes, err := getActiveFreezes(db, application, now)
+	checkErr(t, err)
+	if len(freezes) != 2 {
+		t.Fatalf("wrong number of active freezes. want=%d, got=%d", 2, len(freezes))
+	}
+	if !freezes[0].ExpiresAt.After(freezes[1].ExpiresAt) {
+		t.Errorf("freezes not ordered by expiry: %s, %s", freezes[0].ExpiresAt, freezes[1].ExpiresAt)
+	}
+	if freezes[0].User.Name != user.Name {
+		t.Errorf("wrong user. want=%s, got=%s", user.Name, freezes[0].User.Name)
+	}
+
+	freeze, err := getFreeze(db, freezes[0].Id)
+	checkErr(t, err)
+	if freeze == nil || freeze.Reason != "incident" {
+		t.Errorf("wrong freeze loaded: %+v", freeze)
+	}
+}
@@ -70,6 +70,26 @@ type GitHubDeploymentStatus struct {
 	TargetURL string `json:"target_url"`
 }
 
+type GitHubStatus struct {
+	Context string `json:"context"`
+	State   string `json:"state"`
+}
+
+type GitHubCombinedStatus struct {
+	State    string         `json:"state"`
+	Statuses []GitHubStatus `json:"statuses"`
+}
+
+type GitHubCheckRun struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+}
+
+type GitHubCheckRuns struct {
+	CheckRuns []GitHubCheckRun `json:"check_runs"`
+}
+
 type GitHubClient struct{ *http.Client }
 
 func NewGitHubClient(u *models.User) *GitHubClient {
@@ -156,6 +176,64 @@ func (gc *GitHubClient) Compare(a *models.Application, oldSha, newSha string) (*
 	return diff, nil
 }
 
+// GetCombinedStatus returns the combined CI status of a commit: "success",
+// "pending" or "failure".
+func (gc *GitHubClient) GetCombinedStatus(a *models.Application, sha string) (string, error) {
+	status := &GitHubCombinedStatus{}
+	url := fmt.Sprintf("%s/repos/%s/%s/commits/%s/status",
+		gitHubAPI, a.GitHubOwner, a.GitHubRepo, sha)
+
+	err := gc.GetDecode(url, status)
+	if err != nil {
+		return "", err
+	}
+
+	return status.State, nil
+}
+
+// GetCommitChecks returns the states of the commit statuses and check runs of
+// a commit, keyed by their context or name. Completed check runs that
+// succeeded, were skipped or are neutral have the state "success", check runs
+// that aren't completed yet are "pending".
+func (gc *GitHubClient) GetCommitChecks(a *models.Application, sha string) (map[string]string, error) {
+	checks := map[string]string{}
+
+	status := &GitHubCombinedStatus{}
+	url := fmt.Sprintf("%s/repos/%s/%s/commits/%s/status?per_page=100",
+		gitHubAPI, a.GitHubOwner, a.GitHubRepo, sha)
+
+	err := gc.GetDecode(url, status)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range status.Statuses {
+		checks[s.Context] = s.State
+	}
+
+	runs := &GitHubCheckRuns{}
+	url = fmt.Sprintf("%s/repos/%s/%s/commits/%s/check-runs?per_page=100",
+		gitHubAPI, a.GitHubOwner, a.GitHubRepo, sha)
+
+	err = gc.GetDecode(url, runs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs.CheckRuns {
+		switch {
+		case run.Status != "completed":
+			checks[run.Name] = "pending"
+		case run.Conclusion == "success" || run.Conclusion == "neutral" || run.Conclusion == "skipped":
+			checks[run.Name] = "success"
+		default:
+			checks[run.Name] = run.Conclusion
+		}
+	}
+
+	return checks, nil
+}
+
 func (gc *GitHubClient) UpdateUser(u *models.User) error {
 	url := fmt.Sprintf("%s/user", gitHubAPI)
 
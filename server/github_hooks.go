@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const maxGitHubHookBodySize = 5 << 20
+
+type GitHubHookRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type GitHubPushEvent struct {
+	Ref        string               `json:"ref"`
+	After      string               `json:"after"`
+	Deleted    bool                 `json:"deleted"`
+	Repository GitHubHookRepository `json:"repository"`
+	Pusher     struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	HeadCommit *struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+}
+
+type GitHubHookBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		Sha string `json:"sha"`
+	} `json:"commit"`
+}
+
+type GitHubStatusEvent struct {
+	Sha        string               `json:"sha"`
+	State      string               `json:"state"`
+	Repository GitHubHookRepository `json:"repository"`
+	Branches   []GitHubHookBranch   `json:"branches"`
+}
+
+// githubHookHandler receives the webhooks GitHub sends for push and status
+// events and deploys the pushed commits to the targets that have
+// auto_deploy_branch set to the pushed branch.
+func githubHookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGitHubHookBodySize))
+	if err != nil {
+		http.Error(w, "could not read request body", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Repository GitHubHookRepository `json:"repository"`
+	}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	a := findApplicationByRepository(payload.Repository.FullName)
+	if a == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		signature = r.Header.Get("X-Hub-Signature")
+	}
+	webhookSecret, err := models.ResolveSecret(a.GitHubWebhookSecret, secretResolver())
+	if err != nil {
+		log.Printf("Resolving the webhook secret of %s failed: %s\n", a.Name, err)
+	}
+	if !verifyGitHubSignature(webhookSecret, signature, body) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	var deployments []*models.Deployment
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		fmt.Fprintln(w, "pong")
+		return
+	case "push":
+		push := &GitHubPushEvent{}
+		if err := json.Unmarshal(body, push); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		deployments = autoDeployPush(a, push)
+	case "status":
+		status := &GitHubStatusEvent{}
+		if err := json.Unmarshal(body, status); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		deployments = autoDeployStatus(a, status)
+	default:
+		fmt.Fprintf(w, "ignoring %q event\n", event)
+		return
+	}
+
+	if len(deployments) == 0 {
+		fmt.Fprintln(w, "no deployments started")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	for _, d := range deployments {
+		fmt.Fprintf(w, "deployment %d of %s to %s: %s\n", d.Id, d.CommitSha, d.TargetName, d.State)
+	}
+}
+
+// verifyGitHubSignature checks the signature GitHub sends in the
+// X-Hub-Signature-256 ("sha256=<hex>") or X-Hub-Signature ("sha1=<hex>")
+// header against the HMAC of the body. Without a configured secret every
+// signature is invalid.
+func verifyGitHubSignature(secret, signature string, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	var h func() hash.Hash
+	switch parts[0] {
+	case "sha256":
+		h = sha256.New
+	case "sha1":
+		h = sha1.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func findApplicationByRepository(fullName string) *models.Application {
+	for _, a := range config.Applications {
+		if strings.EqualFold(a.GitHubOwner+"/"+a.GitHubRepo, fullName) {
+			return a
+		}
+	}
+	return nil
+}
+
+// pushTargets returns the targets that auto-deploy the pushed branch. Targets
+// that require CI to pass are skipped, they are deployed once GitHub reports a
+// successful status for the commit.
+func pushTargets(a *models.Application, push *GitHubPushEvent) []*models.Target {
+	targets := []*models.Target{}
+
+	if push.Deleted || !strings.HasPrefix(push.Ref, "refs/heads/") {
+		return targets
+	}
+	branch := strings.TrimPrefix(push.Ref, "refs/heads/")
+
+	for _, t := range a.Targets {
+		if t.AutoDeployBranch == branch && !t.AutoDeployRequireCI {
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+// statusTargets returns the targets requiring CI whose auto-deploy branch
+// points to the commit of a successful status event.
+func statusTargets(a *models.Application, status *GitHubStatusEvent) []*models.Target {
+	targets := []*models.Target{}
+
+	if status.State != "success" {
+		return targets
+	}
+
+	for _, t := range a.Targets {
+		if t.AutoDeployBranch == "" || !t.AutoDeployRequireCI {
+			continue
+		}
+
+		for _, b := range status.Branches {
+			if b.Name == t.AutoDeployBranch && b.Commit.Sha == status.Sha {
+				targets = append(targets, t)
+				break
+			}
+		}
+	}
+
+	return targets
+}
+
+func autoDeployPush(a *models.Application, push *GitHubPushEvent) []*models.Deployment {
+	deployments := []*models.Deployment{}
+
+	branch := strings.TrimPrefix(push.Ref, "refs/heads/")
+	comment := fmt.Sprintf("Auto-deploy of %s pushed by %s", branch, push.Pusher.Name)
+	if push.HeadCommit != nil {
+		message := strings.SplitN(push.HeadCommit.Message, "\n", 2)[0]
+		comment = fmt.Sprintf("%s: %s", comment, message)
+	}
+
+	for _, t := range pushTargets(a, push) {
+		d, err := autoDeploy(a, t, push.After, branch, comment, false)
+		if err != nil {
+			log.Printf("Not auto-deploying %s to %s: %s\n", push.After, t.Name, err)
+			continue
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments
+}
+
+func autoDeployStatus(a *models.Application, status *GitHubStatusEvent) []*models.Deployment {
+	deployments := []*models.Deployment{}
+
+	for _, t := range statusTargets(a, status) {
+		comment := fmt.Sprintf("Auto-deploy of %s after CI passed", t.AutoDeployBranch)
+
+		d, err := autoDeploy(a, t, status.Sha, t.AutoDeployBranch, comment, true)
+		if err != nil {
+			log.Printf("Not auto-deploying %s to %s: %s\n", status.Sha, t.Name, err)
+			continue
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments
+}
+
+// autoDeployLocks serializes the auto-deploys to a target, so that a push and
+// a status event for the same commit, which GitHub can deliver at the same
+// time, don't both find the commit undeployed and deploy it twice.
+var autoDeployLocks = &targetLocks{m: make(map[string]*sync.Mutex)}
+
+type targetLocks struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}
+
+// lock locks the mutex of the target and returns the function that unlocks
+// it.
+func (tl *targetLocks) lock(a *models.Application, t *models.Target) func() {
+	key := a.Name + "/" + t.Name
+
+	tl.Lock()
+	mutex, ok := tl.m[key]
+	if !ok {
+		mutex = &sync.Mutex{}
+		tl.m[key] = mutex
+	}
+	tl.Unlock()
+
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// autoDeploy deploys the commit to the target with its default stages as the
+// target's auto-deploy user. If checkCI is true, the combined status of the
+// commit on GitHub has to be successful.
+func autoDeploy(a *models.Application, t *models.Target, sha, branch, comment string, checkCI bool) (*models.Deployment, error) {
+	if !isValidCommitSha(sha) {
+		return nil, fmt.Errorf("invalid commit sha %q", sha)
+	}
+
+	unlock := autoDeployLocks.lock(a, t)
+	defer unlock()
+
+	u, err := getUserByName(db, t.AutoDeployUsername)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("auto-deploy user %q has never logged in", t.AutoDeployUsername)
+	}
+	if !t.IsDeployer(u.Name) {
+		return nil, fmt.Errorf("auto-deploy user %q can't deploy to %s", u.Name, t.Name)
+	}
+
+	deployed, err := userDeployedCommit(db, a, t.Name, sha, u)
+	if err != nil {
+		return nil, err
+	}
+	if deployed {
+		return nil, fmt.Errorf("commit already auto-deployed")
+	}
+
+	if checkCI {
+		state, err := NewGitHubClient(u).GetCombinedStatus(a, sha)
+		if err != nil {
+			return nil, err
+		}
+		if state != "success" {
+			return nil, fmt.Errorf("combined CI status is %q", state)
+		}
+	}
+
+	_, err = checkFreeze(a, t, u, false, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = checkStatusChecks(a, t, u, sha, false)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &models.Deployment{
+		UserId:          u.Id,
+		CommitSha:       sha,
+		Branch:          branch,
+		Comment:         comment,
+		ApplicationName: a.Name,
+		TargetName:      t.Name,
+		Stages:          t.DefaultStages,
+	}
+
+	err = startDeployment(a, t, d)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Auto-deployed %s of %s to %s as deployment %d\n", sha, branch, t.Name, d.Id)
+	return d, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/develop"}`)
+
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(body)
+	sha256Signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	mac = hmac.New(sha1.New, []byte("s3cr3t"))
+	mac.Write(body)
+	sha1Signature := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		secret    string
+		signature string
+		valid     bool
+	}{
+		{"s3cr3t", sha256Signature, true},
+		{"s3cr3t", sha1Signature, true},
+		{"wrong", sha256Signature, false},
+		{"", sha256Signature, false},
+		{"s3cr3t", "", false},
+		{"s3cr3t", "md5=abc", false},
+		{"s3cr3t", "sha256=nothex", false},
+	}
+
+	for i, tt := range tests {
+		valid := verifyGitHubSignature(tt.secret, tt.signature, body)
+		if valid != tt.valid {
+			t.Errorf("%d: wrong result. want=%t, got=%t", i, tt.valid, valid)
+		}
+	}
+}
+
+func TestGitHubHookHandlerRejectsInvalidSignature(t *testing.T) {
+	config = &Configuration{
+		Applications: []*models.Application{
+			{
+				Name:                "flincOnRails",
+				GitHubOwner:         "flinc",
+				GitHubRepo:          "flincOnRails",
+				GitHubWebhookSecret: "s3cr3t",
+			},
+		},
+	}
+
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{`{"repository":{"full_name":"flinc/flincOnRails"}}`, http.StatusForbidden},
+		{`{"repository":{"full_name":"flinc/unknown"}}`, http.StatusNotFound},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		req, err := http.NewRequest("POST", "/hooks/github", bytes.NewBufferString(tt.body))
+		checkErr(t, err)
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", "sha256=abcdef")
+
+		rec := httptest.NewRecorder()
+		githubHookHandler(rec, req)
+
+		if rec.Code != tt.expected {
+			t.Errorf("%d: wrong status code. want=%d, got=%d", i, tt.expected, rec.Code)
+		}
+	}
+}
+
+func TestGitHubHookHandlerWebhookSecretReference(t *testing.T) {
+	db = newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	secretStore, err = NewSecretStore(db, []byte("master-key"))
+	checkErr(t, err)
+	defer func() { secretStore = nil }()
+
+	err = secretStore.Set("webhook", "s3cr3t", user.Id)
+	checkErr(t, err)
+
+	config = &Configuration{
+		Applications: []*models.Application{
+			{
+				Name:                "flincOnRails",
+				GitHubOwner:         "flinc",
+				GitHubRepo:          "flincOnRails",
+				GitHubWebhookSecret: "secret://webhook",
+			},
+		},
+	}
+
+	body := `{"repository":{"full_name":"flinc/flincOnRails"}}`
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(body))
+
+	req, err := http.NewRequest("POST", "/hooks/github", bytes.NewBufferString(body))
+	checkErr(t, err)
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	rec := httptest.NewRecorder()
+	githubHookHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code. want=%d, got=%d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestPushTargets(t *testing.T) {
+	staging := &models.Target{Name: "staging", AutoDeployBranch: "develop"}
+	sandbox := &models.Target{Name: "sandbox", AutoDeployBranch: "develop", AutoDeployRequireCI: true}
+	production := &models.Target{Name: "production"}
+	application := &models.Application{Targets: []*models.Target{staging, sandbox, production}}
+
+	tests := []struct {
+		push     *GitHubPushEvent
+		expected []*models.Target
+	}{
+		{&GitHubPushEvent{Ref: "refs/heads/develop"}, []*models.Target{staging}},
+		{&GitHubPushEvent{Ref: "refs/heads/master"}, []*models.Target{}},
+		{&GitHubPushEvent{Ref: "refs/tags/develop"}, []*models.Target{}},
+		{&GitHubPushEvent{Ref: "refs/heads/develop", Deleted: true}, []*models.Target{}},
+	}
+
+	for i, tt := range tests {
+		targets := pushTargets(application, tt.push)
+		if len(targets) != len(tt.expected) {
+			t.Errorf("%d: wrong number of targets. want=%d, got=%d", i, len(tt.expected), len(targets))
+			continue
+		}
+		for j, target := range targets {
+			if target != tt.expected[j] {
+				t.Errorf("%d: wrong target. want=%s, got=%s", i, tt.expected[j].Name, target.Name)
+			}
+		}
+	}
+}
+
+func TestStatusTargets(t *testing.T) {
+	staging := &models.Target{Name: "staging", AutoDeployBranch: "develop"}
+	sandbox := &models.Target{Name: "sandbox", AutoDeployBranch: "develop", AutoDeployRequireCI: true}
+	application := &models.Application{Targets: []*models.Target{staging, sandbox}}
+
+	status := func(state, branch, branchSha string) *GitHubStatusEvent {
+		b := GitHubHookBranch{Name: branch}
+		b.Commit.Sha = branchSha
+		return &GitHubStatusEvent{Sha: "f00", State: state, Branches: []GitHubHookBranch{b}}
+	}
+
+	tests := []struct {
+		status   *GitHubStatusEvent
+		expected int
+	}{
+		{status("success", "develop", "f00"), 1},
+		{status("pending", "develop", "f00"), 0},
+		{status("success", "develop", "b4r"), 0},
+		{status("success", "master", "f00"), 0},
+	}
+
+	for i, tt := range tests {
+		targets := statusTargets(application, tt.status)
+		if len(targets) != tt.expected {
+			t.Errorf("%d: wrong number of targets. want=%d, got=%d", i, tt.expected, len(targets))
+			continue
+		}
+		if len(targets) == 1 && targets[0] != sandbox {
+			t.Errorf("%d: wrong target. want=%s, got=%s", i, sandbox.Name, targets[0].Name)
+		}
+	}
+}
+
+func TestUserDeployedCommit(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	bot := buildUser(1, "deploy-bot")
+	err := createUser(db, bot)
+	checkErr(t, err)
+
+	user, err := getUserByName(db, "deploy-bot")
+	checkErr(t, err)
+	if user == nil || user.Id != bot.Id {
+		t.Fatalf("wrong user loaded: %+v", user)
+	}
+
+	user, err = getUserByName(db, "nobody")
+	checkErr(t, err)
+	if user != nil {
+		t.Errorf("expected no user, got %+v", user)
+	}
+
+	application := &models.Application{Name: "flincOnRails"}
+	d := buildDeployment(bot.Id)
+	err = createDeployment(db, d)
+	checkErr(t, err)
+
+	tests := []struct {
+		target    string
+		commitSha string
+		userId    int
+		expected  bool
+	}{
+		{d.TargetName, d.CommitSha, bot.Id, true},
+		{d.TargetName, "b4r", bot.Id, false},
+		{"sandbox", d.CommitSha, bot.Id, false},
+		{d.TargetName, d.CommitSha, 2, false},
+	}
+
+	for i, tt := range tests {
+		deployed, err := userDeployedCommit(db, application, tt.target, tt.commitSha, &models.User{Id: tt.userId})
+		checkErr(t, err)
+		if deployed != tt.expected {
+			t.Errorf("%d: wrong result. want=%t, got=%t", i, tt.expected, deployed)
+		}
+	}
+	states := []struct {
+		state    models.DeploymentState
+		expected bool
+	}{
+		{models.DEPLOYMENT_PENDING_APPROVAL, true},
+		{models.DEPLOYMENT_ACTIVE, true},
+		{models.DEPLOYMENT_PARTIALLY_SUCCESSFUL, true},
+		{models.DEPLOYMENT_FAILED, false},
+		{models.DEPLOYMENT_CANCELLED, false},
+		{models.DEPLOYMENT_REJECTED, false},
+		{models.DEPLOYMENT_KILLED, false},
+	}
+
+	for _, tt := range states {
+		err = updateDeploymentState(db, d, tt.state)
+		checkErr(t, err)
+
+		deployed, err := userDeployedCommit(db, application, d.TargetName, d.CommitSha, bot)
+		checkErr(t, err)
+		if deployed != tt.expected {
+			t.Errorf("%s: wrong result. want=%t, got=%t", tt.state, tt.expected, deployed)
+		}
+	}
+}
+
+func TestTargetLocks(t *testing.T) {
+	locks := &targetLocks{m: make(map[string]*sync.Mutex)}
+
+	production := &models.Target{Name: "production"}
+	staging := &models.Target{Name: "staging"}
+	application := &models.Application{Name: "flincOnRails", Targets: []*models.Target{production, staging}}
+
+	unlock := locks.lock(application, production)
+
+	// Another target is not blocked
+	locks.lock(application, staging)()
+
+	locked := make(chan struct{})
+	go func() {
+		locks.lock(application, production)()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatalf("target locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Errorf("target not unlocked")
+	}
+}
@@ -56,9 +56,9 @@ func (notifier *GitHubNotifier) NewStatus(ev *DeploymentEvent) *GitHubDeployment
 	switch ev.State {
 	case models.DEPLOYMENT_ACTIVE:
 		deploymentStatus.State = "pending"
-	case models.DEPLOYMENT_SUCCESSFUL:
+	case models.DEPLOYMENT_SUCCESSFUL, models.DEPLOYMENT_PARTIALLY_SUCCESSFUL:
 		deploymentStatus.State = "success"
-	case models.DEPLOYMENT_FAILED:
+	case models.DEPLOYMENT_FAILED, models.DEPLOYMENT_ROLLED_BACK, models.DEPLOYMENT_KILLED:
 		deploymentStatus.State = "failure"
 	}
 
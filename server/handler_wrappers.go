@@ -83,3 +83,15 @@ func authorizedReaders(fn http.HandlerFunc) http.HandlerFunc {
 		}
 	}
 }
+
+func requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return authenticated(func(w http.ResponseWriter, r *http.Request) {
+		currentUser := getCurrentUser(r)
+
+		if config.IsAdmin(currentUser.Name) {
+			fn(w, r)
+		} else {
+			http.Error(w, "not authorized to manage secrets", 403)
+		}
+	})
+}
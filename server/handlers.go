@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -54,11 +55,47 @@ func applicationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = loadQueuePositions(db, application, deployments)
+	if err != nil {
+		log.Println("error loading the queue positions of the deployments", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	freezes, err := activeFreezes(application, time.Now())
+	if err != nil {
+		log.Println("error loading freezes", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pipeline, err := getPipelinePositions(db, application)
+	if err != nil {
+		log.Println("error loading pipeline", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	canOverrideFreeze := false
+	requiresStatusChecks := false
+	for _, t := range application.Targets {
+		if t.CanOverrideFreeze(currentUser.Name) {
+			canOverrideFreeze = true
+		}
+		if len(t.RequiredStatusChecks) > 0 {
+			requiresStatusChecks = true
+		}
+	}
+
 	renderTemplate(w, "application.tmpl", map[string]interface{}{
-		"Applications": config.Applications,
-		"Application":  application,
-		"Deployments":  deployments,
-		"currentUser":  currentUser,
+		"Applications":         config.Applications,
+		"Application":          application,
+		"Deployments":          deployments,
+		"Freezes":              freezes,
+		"Pipeline":             pipeline,
+		"CanOverrideFreeze":    canOverrideFreeze,
+		"RequiresStatusChecks": requiresStatusChecks,
+		"currentUser":          currentUser,
 	})
 }
 
@@ -234,65 +271,164 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deployment := &models.Deployment{
-		UserId:          currentUser.Id,
-		CommitSha:       commitSha,
-		Branch:          r.FormValue("branch"),
-		Comment:         r.FormValue("comment"),
-		ApplicationName: application.Name,
-		TargetName:      target.Name,
+	scheduledAt, err := parseScheduledAt(r.FormValue("scheduled_at"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
 	}
 
-	err = createDeployment(db, deployment)
+	// Scheduled deployments are checked against the freezes at the time
+	// they are scheduled for
+	freezeCheckTime := time.Now()
+	if scheduledAt != nil {
+		freezeCheckTime = *scheduledAt
+	}
+
+	freezeOverride, err := checkFreeze(application, target, currentUser, r.FormValue("override_freeze") != "", freezeCheckTime)
 	if err != nil {
-		log.Println("Could not save to database", err)
+		if _, ok := err.(*FrozenError); ok {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error checking freezes", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	eventHub.Publish(deployment.State, deployment)
-	killChan := killRegistry.Add(deployment.Id)
+	statusChecksOverride, err := checkStatusChecks(application, target, currentUser, commitSha, r.FormValue("override_status_checks") != "")
+	if err != nil {
+		if _, ok := err.(*StatusChecksError); ok {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error checking status checks", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	deploymentConfig := models.NewDeploymentConfig(deployment, target, stages)
-	manager, err := deploy.NewManager(deploymentConfig, logRouter, killChan)
+	deployment := &models.Deployment{
+		UserId:               currentUser.Id,
+		CommitSha:            commitSha,
+		Branch:               r.FormValue("branch"),
+		Comment:              r.FormValue("comment"),
+		ApplicationName:      application.Name,
+		TargetName:           target.Name,
+		Stages:               stages,
+		FreezeOverride:       freezeOverride,
+		StatusChecksOverride: statusChecksOverride,
+		ScheduledAt:          scheduledAt,
+	}
+
+	err = startDeployment(application, target, deployment)
 	if err != nil {
-		log.Println("Could not build Manager", err)
+		log.Println("Could not start deployment", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	manager.AnnounceStart()
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+func redeployHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
 
-	err = updateDeploymentState(db, deployment, models.DEPLOYMENT_ACTIVE)
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
 	if err != nil {
-		log.Println("Could not update deployment state")
-		killRegistry.Remove(deployment.Id)
+		log.Println("error converting ID passed to server", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	eventHub.Publish(models.DEPLOYMENT_ACTIVE, deployment)
 
-	go func() {
-		newState := models.DEPLOYMENT_SUCCESSFUL
-		err = manager.Start()
-		if err != nil {
-			newState = models.DEPLOYMENT_FAILED
+	previous, err := getDeployment(db, id)
+	if err != nil {
+		log.Println("error loading deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if previous == nil || previous.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := findTarget(application, previous.TargetName)
+	if err != nil {
+		log.Printf("error: %s\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !target.IsDeployer(currentUser.Name) {
+		http.Error(w, "not authorized to deploy to this target", 403)
+		return
+	}
+
+	comment := r.FormValue("comment")
+	if comment == "" {
+		http.Error(w, "comment is empty", 422)
+		return
+	}
+
+	// Deployments created before the stages were saved have none
+	stages := previous.Stages
+	if len(stages) == 0 {
+		stages = target.DefaultStages
+	}
+
+	if !target.AreValidStages(stages) {
+		msg := "stages of deployment %d are not valid anymore. Available stages: %v"
+		http.Error(w, fmt.Sprintf(msg, previous.Id, target.AvailableStages), 422)
+		return
+	}
+
+	freezeOverride, err := checkFreeze(application, target, currentUser, r.FormValue("override_freeze") != "", time.Now())
+	if err != nil {
+		if _, ok := err.(*FrozenError); ok {
+			http.Error(w, err.Error(), 422)
+			return
 		}
+		log.Println("error checking freezes", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		err = updateDeploymentState(db, deployment, newState)
-		if err != nil {
-			log.Println("Could not update deployment state")
-		} else {
-			eventHub.Publish(newState, deployment)
+	statusChecksOverride, err := checkStatusChecks(application, target, currentUser, previous.CommitSha, r.FormValue("override_status_checks") != "")
+	if err != nil {
+		if _, ok := err.(*StatusChecksError); ok {
+			http.Error(w, err.Error(), 422)
+			return
 		}
+		log.Println("error checking status checks", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		killRegistry.Remove(deployment.Id)
-	}()
+	deployment := &models.Deployment{
+		UserId:               currentUser.Id,
+		CommitSha:            previous.CommitSha,
+		Branch:               previous.Branch,
+		Comment:              comment,
+		ApplicationName:      application.Name,
+		TargetName:           target.Name,
+		Stages:               stages,
+		FreezeOverride:       freezeOverride,
+		StatusChecksOverride: statusChecksOverride,
+	}
+
+	err = startDeployment(application, target, deployment)
+	if err != nil {
+		log.Println("Could not start deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
 }
 
 func killDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["deploymentId"])
 	if err != nil {
@@ -301,13 +437,410 @@ func killDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	killChan, err := killRegistry.Get(id)
+	err = killRegistry.Kill(id, currentUser.Name)
 	if err != nil {
 		http.Error(w, err.Error(), 422)
 		return
 	}
+}
+
+func cancelDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
+	if err != nil {
+		log.Println("error converting ID passed to server", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		log.Println("error loading deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	if deployment.UserId != currentUser.Id {
+		http.Error(w, "only the creator can cancel a deployment", 403)
+		return
+	}
+
+	err = cancelDeployment(db, deployment)
+	if err != nil {
+		if err == ErrNotWaiting {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error cancelling deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	eventHub.Publish(models.DEPLOYMENT_CANCELLED, deployment)
 
-	killChan <- struct{}{}
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+func rescheduleDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
+	if err != nil {
+		log.Println("error converting ID passed to server", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		log.Println("error loading deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	if deployment.UserId != currentUser.Id {
+		http.Error(w, "only the creator can reschedule a deployment", 403)
+		return
+	}
+
+	scheduledAt, err := parseScheduledAt(r.FormValue("scheduled_at"), time.Now())
+	if err != nil || scheduledAt == nil {
+		http.Error(w, "invalid scheduled time", 422)
+		return
+	}
+
+	err = rescheduleDeployment(db, deployment, *scheduledAt)
+	if err != nil {
+		if err == ErrNotScheduled {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error rescheduling deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+func promoteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
+	if err != nil {
+		log.Println("error converting ID passed to server", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	previous, err := getDeployment(db, id)
+	if err != nil {
+		log.Println("error loading deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if previous == nil || previous.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	if previous.State != models.DEPLOYMENT_SUCCESSFUL {
+		http.Error(w, "only successful deployments can be promoted", 422)
+		return
+	}
+
+	stage := application.NextPipelineStage(previous.TargetName)
+	if stage == nil {
+		http.Error(w, "target has no next stage in the pipeline", 422)
+		return
+	}
+
+	target, err := findTarget(application, stage.Target)
+	if err != nil {
+		log.Printf("error: %s\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !target.IsDeployer(currentUser.Name) {
+		http.Error(w, "not authorized to deploy to this target", 403)
+		return
+	}
+
+	freezeOverride, err := checkFreeze(application, target, currentUser, r.FormValue("override_freeze") != "", time.Now())
+	if err != nil {
+		if _, ok := err.(*FrozenError); ok {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error checking freezes", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statusChecksOverride, err := checkStatusChecks(application, target, currentUser, previous.CommitSha, r.FormValue("override_status_checks") != "")
+	if err != nil {
+		if _, ok := err.(*StatusChecksError); ok {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error checking status checks", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployment := buildPromotion(previous, target, currentUser, r.FormValue("comment"))
+	deployment.FreezeOverride = freezeOverride
+	deployment.StatusChecksOverride = statusChecksOverride
+
+	err = startDeployment(application, target, deployment)
+	if err != nil {
+		log.Println("Could not start deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+func approveDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	decideDeploymentHandler(w, r, true)
+}
+
+func rejectDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	decideDeploymentHandler(w, r, false)
+}
+
+func decideDeploymentHandler(w http.ResponseWriter, r *http.Request, approved bool) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
+	if err != nil {
+		log.Println("error converting ID passed to server", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		log.Println("error loading deployment", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := findTarget(application, deployment.TargetName)
+	if err != nil {
+		log.Printf("error: %s\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !target.IsApprover(currentUser.Name) {
+		http.Error(w, "not authorized to approve deployments to this target", 403)
+		return
+	}
+
+	if deployment.UserId == currentUser.Id {
+		http.Error(w, "deployments can't be approved by their creator", 403)
+		return
+	}
+
+	approval := &models.Approval{
+		UserId:   currentUser.Id,
+		User:     currentUser,
+		Approved: approved,
+		Comment:  r.FormValue("comment"),
+	}
+
+	err = decideDeployment(application, target, deployment, approval)
+	if err != nil {
+		if err == ErrNotPendingApproval || err == ErrAlreadyDecided {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+		log.Println("error saving approval", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+func createFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	target, err := findTarget(application, r.FormValue("target"))
+	if err != nil {
+		log.Printf("error: %s\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !target.IsDeployer(currentUser.Name) {
+		http.Error(w, "not authorized to freeze this target", 403)
+		return
+	}
+
+	reason := r.FormValue("reason")
+	if reason == "" {
+		http.Error(w, "reason is empty", 422)
+		return
+	}
+
+	hours, err := strconv.Atoi(r.FormValue("hours"))
+	if err != nil || hours <= 0 {
+		http.Error(w, "invalid number of hours", 422)
+		return
+	}
+
+	freeze := &models.Freeze{
+		ApplicationName: application.Name,
+		TargetName:      target.Name,
+		UserId:          currentUser.Id,
+		Reason:          reason,
+		ExpiresAt:       time.Now().Add(time.Duration(hours) * time.Hour),
+	}
+
+	err = createFreeze(db, freeze)
+	if err != nil {
+		log.Println("error saving freeze", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
+
+func liftFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["freezeId"])
+	if err != nil {
+		log.Println("error converting ID passed to server", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	freeze, err := getFreeze(db, id)
+	if err != nil {
+		log.Println("error loading freeze", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if freeze == nil || freeze.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, err := findTarget(application, freeze.TargetName)
+	if err != nil {
+		log.Printf("error: %s\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if freeze.UserId != currentUser.Id && !target.CanOverrideFreeze(currentUser.Name) {
+		http.Error(w, "not authorized to lift this freeze", 403)
+		return
+	}
+
+	err = liftFreeze(db, freeze, currentUser.Name)
+	if err != nil {
+		log.Println("error lifting freeze", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
+
+func secretsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	secrets, err := getSecrets(db)
+	if err != nil {
+		log.Println("error loading secrets", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "secrets.tmpl", map[string]interface{}{
+		"Applications": config.Applications,
+		"Secrets":      secrets,
+		"StoreEnabled": secretStore != nil,
+		"currentUser":  currentUser,
+	})
+}
+
+func createSecretHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	if secretStore == nil {
+		http.Error(w, "no secrets key configured", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.FormValue("name")
+	if !secretNamePattern.MatchString(name) {
+		http.Error(w, "invalid secret name", 422)
+		return
+	}
+
+	value := r.FormValue("value")
+	if value == "" {
+		http.Error(w, "value is empty", 422)
+		return
+	}
+
+	err := secretStore.Set(name, value, currentUser.Id)
+	if err != nil {
+		log.Println("error saving secret", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+}
+
+func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if secretStore == nil {
+		http.Error(w, "no secrets key configured", http.StatusInternalServerError)
+		return
+	}
+
+	err := secretStore.Delete(vars["name"])
+	if err != nil {
+		log.Println("error deleting secret", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
 }
 
 func listDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
@@ -336,6 +869,13 @@ func listDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = loadQueuePositions(db, application, deployments)
+	if err != nil {
+		log.Println("error loading the queue positions of the deployments", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	renderTemplate(w, "deployments.tmpl", map[string]interface{}{
 		"Applications":   config.Applications,
 		"Application":    application,
@@ -371,6 +911,13 @@ func deploymentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	deployment.User = deploymentUser
 
+	err = loadQueuePositions(db, application, []*models.Deployment{deployment})
+	if err != nil {
+		log.Println("error loading queue position", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	logEntries, err := getDeploymentLogEntries(db, deployment)
 	if err != nil {
 		log.Println("error loading logentries", err)
@@ -378,11 +925,39 @@ func deploymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scripts, err := getDeploymentScripts(db, deployment)
+	if err != nil {
+		log.Println("error loading deployment scripts", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	approvals, err := getDeploymentApprovals(db, deployment)
+	if err != nil {
+		log.Println("error loading approvals", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The target might have been removed from the configuration in the meantime
+	target, _ := findTarget(application, deployment.TargetName)
+
+	nextStage := application.NextPipelineStage(deployment.TargetName)
+	var nextTarget *models.Target
+	if nextStage != nil {
+		nextTarget, _ = findTarget(application, nextStage.Target)
+	}
+
 	renderTemplate(w, "deployment.tmpl", map[string]interface{}{
 		"Applications": config.Applications,
 		"Application":  application,
 		"Deployment":   deployment,
+		"Target":       target,
 		"LogEntries":   logEntries,
+		"Scripts":      scripts,
+		"Approvals":    approvals,
+		"NextStage":    nextStage,
+		"NextTarget":   nextTarget,
 		"currentUser":  currentUser,
 		"Host":         r.Host,
 	})
@@ -559,6 +1134,29 @@ func findTarget(a *models.Application, name string) (*models.Target, error) {
 	return nil, errors.New("target not found")
 }
 
+// parseScheduledAt parses the time a deployment is scheduled for, either in
+// RFC 3339 format or as "2006-01-02T15:04" in the server's time zone, as sent
+// by datetime-local inputs. It returns nil if value is empty.
+func parseScheduledAt(value string, now time.Time) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	scheduledAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		scheduledAt, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduled time: %q", value)
+		}
+	}
+
+	if !scheduledAt.After(now) {
+		return nil, errors.New("scheduled time is in the past")
+	}
+
+	return &scheduledAt, nil
+}
+
 func deploymentUrl(a *models.Application, d *models.Deployment) string {
 	return fmt.Sprintf("/%s/deployments/%d", a.Name, d.Id)
 }
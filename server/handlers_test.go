@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestIsValidCommitSha(t *testing.T) {
 	tests := []struct {
@@ -24,3 +27,41 @@ func TestIsValidCommitSha(t *testing.T) {
 		}
 	}
 }
+
+func TestParseScheduledAt(t *testing.T) {
+	now := time.Date(2016, 3, 16, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"2016-03-17T06:00:00Z", time.Date(2016, 3, 17, 6, 0, 0, 0, time.UTC), false},
+		{"2016-03-17T06:00:00+01:00", time.Date(2016, 3, 17, 5, 0, 0, 0, time.UTC), false},
+		{"2016-03-17T06:00", time.Date(2016, 3, 17, 6, 0, 0, 0, time.Local), false},
+		{"2016-03-16T07:00:00Z", time.Time{}, true},
+		{"tomorrow", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseScheduledAt(tt.input, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected error for input=%s", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for input=%s: %s", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("wrong time. input=%s, want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	got, err := parseScheduledAt("", now)
+	if got != nil || err != nil {
+		t.Errorf("expected nil for empty input, got=%v, err=%v", got, err)
+	}
+}
@@ -7,17 +7,19 @@ import (
 
 type KillRegistry struct {
 	sync.RWMutex
-	m map[int]chan struct{}
+	m map[int]chan string
 }
 
 func NewKillRegistry() *KillRegistry {
 	return &KillRegistry{
-		m: make(map[int]chan struct{}),
+		m: make(map[int]chan string),
 	}
 }
 
-func (kr *KillRegistry) Add(deploymentId int) chan struct{} {
-	c := make(chan struct{})
+func (kr *KillRegistry) Add(deploymentId int) chan string {
+	// Buffered, so a kill isn't lost if the deployment hasn't started
+	// listening on the channel yet
+	c := make(chan string, 1)
 
 	kr.Lock()
 	kr.m[deploymentId] = c
@@ -35,13 +37,21 @@ func (kr *KillRegistry) Remove(deploymentId int) {
 	kr.Unlock()
 }
 
-func (kr *KillRegistry) Get(deploymentId int) (chan struct{}, error) {
+// Kill sends the name of the killer to the deployment's kill channel. It
+// doesn't block if the deployment is already being killed.
+func (kr *KillRegistry) Kill(deploymentId int, killer string) error {
 	kr.RLock()
 	defer kr.RUnlock()
 
 	c, ok := kr.m[deploymentId]
 	if !ok {
-		return nil, fmt.Errorf("no kill channel for deployment id %d found", deploymentId)
+		return fmt.Errorf("no kill channel for deployment id %d found", deploymentId)
 	}
-	return c, nil
+
+	select {
+	case c <- killer:
+	default:
+	}
+
+	return nil
 }
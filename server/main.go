@@ -79,6 +79,7 @@ var (
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "application.tmpl"},
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "deployments.tmpl"},
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "deployment.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "secrets.tmpl"},
 	}
 )
 
@@ -117,6 +118,17 @@ func main() {
 		log.Fatal("please migrate the database to the newest version")
 	}
 
+	secretsKey, err := readSecretsKey(config)
+	if err != nil {
+		log.Fatal("could not read the secrets key", err)
+	}
+	if secretsKey != nil {
+		secretStore, err = NewSecretStore(db, secretsKey)
+		if err != nil {
+			log.Fatal("could not set up the secret store", err)
+		}
+	}
+
 	// If there are deployments in state 'new'/'active' when booting up
 	// Applikatoni probably crashed with a deployment running. Set these to
 	// 'failed' so we can start other deployments.
@@ -166,12 +178,18 @@ func main() {
 	flowdockStates := []models.DeploymentState{
 		models.DEPLOYMENT_SUCCESSFUL,
 		models.DEPLOYMENT_FAILED,
+		models.DEPLOYMENT_ROLLED_BACK,
+		models.DEPLOYMENT_KILLED,
+		models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
 	}
 	eventHub.Subscribe(flowdockStates, NotifyFlowdock)
 	// Subscribe the Slack notifier
 	slackStates := []models.DeploymentState{
 		models.DEPLOYMENT_SUCCESSFUL,
 		models.DEPLOYMENT_FAILED,
+		models.DEPLOYMENT_ROLLED_BACK,
+		models.DEPLOYMENT_KILLED,
+		models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
 	}
 	eventHub.Subscribe(slackStates, NotifySlack)
 	// Subscribe the GitHub notifier to use the Deployments API
@@ -181,18 +199,40 @@ func main() {
 		models.DEPLOYMENT_ACTIVE,
 		models.DEPLOYMENT_SUCCESSFUL,
 		models.DEPLOYMENT_FAILED,
+		models.DEPLOYMENT_ROLLED_BACK,
+		models.DEPLOYMENT_KILLED,
+		models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
 	}
 	eventHub.Subscribe(githubStates, githubNotifier.Notify)
 
+	// Promote successful deployments along the pipelines
+	pipelineStates := []models.DeploymentState{models.DEPLOYMENT_SUCCESSFUL}
+	eventHub.Subscribe(pipelineStates, AutoPromote)
+
 	// Subscribe the webhooks
 	webhookStates := []models.DeploymentState{
 		models.DEPLOYMENT_NEW,
 		models.DEPLOYMENT_ACTIVE,
 		models.DEPLOYMENT_SUCCESSFUL,
 		models.DEPLOYMENT_FAILED,
+		models.DEPLOYMENT_ROLLED_BACK,
+		models.DEPLOYMENT_KILLED,
+		models.DEPLOYMENT_QUEUED,
+		models.DEPLOYMENT_CANCELLED,
+		models.DEPLOYMENT_PENDING_APPROVAL,
+		models.DEPLOYMENT_REJECTED,
+		models.DEPLOYMENT_SCHEDULED,
+		models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
 	}
 	eventHub.Subscribe(webhookStates, NotifyWebhooks)
 
+	// Start the deployments that were still waiting in the queue of their
+	// target when Applikatoni was shut down
+	startQueuedDeployments(config.Applications)
+
+	// Start scheduled deployments in the background once they are due
+	go RunScheduledDeployments(db)
+
 	// Setup the router and the routes
 	r := mux.NewRouter()
 
@@ -208,12 +248,28 @@ func main() {
 	r.HandleFunc("/oauth2/callback", oauth2callbackHandler)
 	r.HandleFunc("/oauth2/logout", oauth2logoutHandler)
 
+	// GitHub webhooks, authenticated with the application's webhook secret
+	r.HandleFunc("/hooks/github", githubHookHandler).Methods("POST")
+
+	// Secrets, only for admins
+	r.HandleFunc("/secrets", authenticate(requireAdmin(secretsHandler))).Methods("GET")
+	r.HandleFunc("/secrets", authenticate(requireAdmin(createSecretHandler))).Methods("POST")
+	r.HandleFunc("/secrets/{name}/delete", authenticate(requireAdmin(deleteSecretHandler))).Methods("POST")
+
 	// Application
 	r.HandleFunc("/{application}/deployments", requireAuthorizedUser(createDeploymentHandler)).Methods("POST")
 	r.HandleFunc("/{application}/deployments", requireAuthorizedUser(listDeploymentsHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}", requireAuthorizedUser(deploymentHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}/log", requireAuthorizedUser(deploymentWsHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}/kill", requireAuthorizedUser(killDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/cancel", requireAuthorizedUser(cancelDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/approve", requireAuthorizedUser(approveDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/reject", requireAuthorizedUser(rejectDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/reschedule", requireAuthorizedUser(rescheduleDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/promote", requireAuthorizedUser(promoteDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/redeploy", requireAuthorizedUser(redeployHandler)).Methods("POST")
+	r.HandleFunc("/{application}/freezes", requireAuthorizedUser(createFreezeHandler)).Methods("POST")
+	r.HandleFunc("/{application}/freezes/{freezeId}/lift", requireAuthorizedUser(liftFreezeHandler)).Methods("POST")
 	r.HandleFunc("/{application}/pulls", requireAuthorizedUser(pullRequestsHandler)).Methods("GET")
 	r.HandleFunc("/{application}/branches", requireAuthorizedUser(branchesHandler)).Methods("GET")
 	r.HandleFunc("/{application}/diff", requireAuthorizedUser(diffHandler)).Methods("GET")
@@ -23,15 +23,19 @@ func generateSummary(t *template.Template, ev *DeploymentEvent) (string, error)
 
 	var summary bytes.Buffer
 	err := t.Execute(&summary, map[string]interface{}{
-		"GitHubRepo":    ev.Application.GitHubRepo,
-		"Success":       success,
-		"Branch":        ev.Deployment.Branch,
-		"Target":        ev.Deployment.TargetName,
-		"Username":      ev.User.Name,
-		"Comment":       ev.Deployment.Comment,
-		"CommentLines":  strings.Split(ev.Deployment.Comment, "\n"),
-		"GitHubUrl":     gitHubUrl,
-		"DeploymentURL": ev.DeploymentURL(),
+		"GitHubRepo":          ev.Application.GitHubRepo,
+		"Success":             success,
+		"PartiallySuccessful": ev.State == models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
+		"FailedHosts":         strings.Join(ev.Deployment.FailedHosts, ", "),
+		"RolledBack":          ev.State == models.DEPLOYMENT_ROLLED_BACK,
+		"Killed":              ev.State == models.DEPLOYMENT_KILLED,
+		"Branch":              ev.Deployment.Branch,
+		"Target":              ev.Deployment.TargetName,
+		"Username":            ev.User.Name,
+		"Comment":             ev.Deployment.Comment,
+		"CommentLines":        strings.Split(ev.Deployment.Comment, "\n"),
+		"GitHubUrl":           gitHubUrl,
+		"DeploymentURL":       ev.DeploymentURL(),
 	})
 
 	return summary.String(), err
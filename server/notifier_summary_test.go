@@ -41,6 +41,13 @@ func TestGenerateSummary(t *testing.T) {
 	expectedSuccessMsg := `main-web-app Successfully Deployed:
 Foo Bar deployed master on staging :pizza:
 
+> hi
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
+<https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
+
+	expectedRolledBackMsg := `main-web-app Deploy Failed and Rolled Back:
+Foo Bar deployed master on staging :pizza:
+
 > hi
 <https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
 <https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
@@ -48,6 +55,14 @@ Foo Bar deployed master on staging :pizza:
 	expectedFailMsg := `main-web-app Deploy Failed:
 Foo Bar deployed master on staging :pizza:
 
+> hi
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
+<https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
+
+	expectedPartialMsg := `main-web-app Partially Deployed:
+Foo Bar deployed master on staging :pizza:
+Failed hosts: web1:22, web2:22
+
 > hi
 <https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
 <https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
@@ -71,4 +86,25 @@ Foo Bar deployed master on staging :pizza:
 	if expectedFailMsg != actualFailMsg {
 		t.Errorf("sent wrong message expected=%v got=%v", expectedFailMsg, actualFailMsg)
 	}
+
+	event.State = models.DEPLOYMENT_ROLLED_BACK
+	actualRolledBackMsg, err := generateSummary(slackTemplate, event)
+	if err != nil {
+		t.Errorf("generateSummary returned err: %s\n", err)
+	}
+
+	if expectedRolledBackMsg != actualRolledBackMsg {
+		t.Errorf("sent wrong message expected=%v got=%v", expectedRolledBackMsg, actualRolledBackMsg)
+	}
+
+	event.State = models.DEPLOYMENT_PARTIALLY_SUCCESSFUL
+	deployment.FailedHosts = []string{"web1:22", "web2:22"}
+	actualPartialMsg, err := generateSummary(slackTemplate, event)
+	if err != nil {
+		t.Errorf("generateSummary returned err: %s\n", err)
+	}
+
+	if expectedPartialMsg != actualPartialMsg {
+		t.Errorf("sent wrong message expected=%v got=%v", expectedPartialMsg, actualPartialMsg)
+	}
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// PipelinePosition is the last successful or partially successful deployment
+// to a stage of an application's pipeline, nil if there is none.
+type PipelinePosition struct {
+	Stage      *models.PipelineStage
+	Deployment *models.Deployment
+	// Promotable is true if the deployment was completely successful and its
+	// commit has not been deployed to the next stage yet
+	Promotable bool
+}
+
+func getPipelinePositions(db *sql.DB, a *models.Application) ([]*PipelinePosition, error) {
+	positions := []*PipelinePosition{}
+
+	for _, stage := range a.Pipeline {
+		d, err := getLastTargetDeployment(db, a, stage.Target)
+		if err != nil {
+			return positions, err
+		}
+		if d != nil {
+			d.User, err = getUser(db, d.UserId)
+			if err != nil {
+				return positions, err
+			}
+		}
+
+		positions = append(positions, &PipelinePosition{Stage: stage, Deployment: d})
+	}
+
+	for i, p := range positions {
+		if p.Deployment == nil || i+1 == len(positions) {
+			continue
+		}
+
+		next := positions[i+1].Deployment
+		p.Promotable = p.Deployment.State == models.DEPLOYMENT_SUCCESSFUL &&
+			(next == nil || next.CommitSha != p.Deployment.CommitSha)
+	}
+
+	return positions, nil
+}
+
+// buildPromotion returns a new deployment of the commit of d to the target t,
+// the next stage in the pipeline.
+func buildPromotion(d *models.Deployment, t *models.Target, u *models.User, comment string) *models.Deployment {
+	if comment == "" {
+		comment = fmt.Sprintf("Promote #%d from %s: %s", d.Id, d.TargetName, d.Comment)
+	}
+
+	return &models.Deployment{
+		UserId:          u.Id,
+		CommitSha:       d.CommitSha,
+		Branch:          d.Branch,
+		Comment:         comment,
+		ApplicationName: d.ApplicationName,
+		TargetName:      t.Name,
+		Stages:          t.DefaultStages,
+		PromotedFrom:    d.Id,
+	}
+}
+
+// AutoPromote promotes successful deployments to the next stage of the
+// pipeline, if that stage has auto_promote set. The promotion is skipped if
+// the deployer can't deploy to the next stage, if it is frozen or if its
+// required status checks aren't passing.
+func AutoPromote(ev *DeploymentEvent) {
+	stage := ev.Application.NextPipelineStage(ev.Target.Name)
+	if stage == nil || !stage.AutoPromote {
+		return
+	}
+
+	target, err := findTarget(ev.Application, stage.Target)
+	if err != nil {
+		log.Printf("Could not promote deployment %d: %s\n", ev.Deployment.Id, err)
+		return
+	}
+
+	if !target.IsDeployer(ev.User.Name) {
+		log.Printf("Not promoting deployment %d: %s can't deploy to %s\n",
+			ev.Deployment.Id, ev.User.Name, target.Name)
+		return
+	}
+
+	_, err = checkFreeze(ev.Application, target, ev.User, false, time.Now())
+	if err != nil {
+		log.Printf("Not promoting deployment %d: %s\n", ev.Deployment.Id, err)
+		return
+	}
+
+	_, err = checkStatusChecks(ev.Application, target, ev.User, ev.Deployment.CommitSha, false)
+	if err != nil {
+		log.Printf("Not promoting deployment %d: %s\n", ev.Deployment.Id, err)
+		return
+	}
+
+	promotion := buildPromotion(ev.Deployment, target, ev.User, "")
+
+	err = startDeployment(ev.Application, target, promotion)
+	if err != nil {
+		log.Printf("Could not promote deployment %d: %s\n", ev.Deployment.Id, err)
+		return
+	}
+
+	log.Printf("Promoted deployment %d to %s as deployment %d\n",
+		ev.Deployment.Id, target.Name, promotion.Id)
+}
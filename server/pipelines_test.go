@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestGetPipelinePositions(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	application := &models.Application{
+		Name: "flincOnRails",
+		Pipeline: []*models.PipelineStage{
+			{Target: "staging"},
+			{Target: "production"},
+			{Target: "sandbox"},
+		},
+	}
+
+	deploy := func(target, sha string, state models.DeploymentState) {
+		d := buildDeployment(user.Id)
+		d.TargetName = target
+		d.CommitSha = sha
+		err := createDeployment(db, d)
+		checkErr(t, err)
+		err = updateDeploymentState(db, d, state)
+		checkErr(t, err)
+	}
+
+	deploy("staging", "f00", models.DEPLOYMENT_SUCCESSFUL)
+	deploy("production", "f00", models.DEPLOYMENT_SUCCESSFUL)
+	deploy("staging", "b4r", models.DEPLOYMENT_SUCCESSFUL)
+	deploy("production", "b4r", models.DEPLOYMENT_FAILED)
+	deploy("sandbox", "f00", models.DEPLOYMENT_SUCCESSFUL)
+
+	positions, err := getPipelinePositions(db, application)
+	checkErr(t, err)
+
+	tests := []struct {
+		commitSha  string
+		promotable bool
+	}{
+		{"b4r", true},
+		{"f00", false},
+		{"f00", false},
+	}
+
+	if len(positions) != len(tests) {
+		t.Fatalf("wrong number of positions. want=%d, got=%d", len(tests), len(positions))
+	}
+
+	for i, tt := range tests {
+		p := positions[i]
+
+		var commitSha string
+		if p.Deployment != nil {
+			commitSha = p.Deployment.CommitSha
+			if p.Deployment.User == nil || p.Deployment.User.Name != user.Name {
+				t.Errorf("%d: user not loaded", i)
+			}
+		}
+
+		if commitSha != tt.commitSha {
+			t.Errorf("%d: wrong commit. want=%q, got=%q", i, tt.commitSha, commitSha)
+		}
+		if p.Promotable != tt.promotable {
+			t.Errorf("%d: wrong promotable. want=%t, got=%t", i, tt.promotable, p.Promotable)
+		}
+	}
+}
+
+func TestBuildPromotion(t *testing.T) {
+	user := buildUser(2, "alice")
+	target := &models.Target{
+		Name:          "production",
+		DefaultStages: []models.DeploymentStage{"CODE_DEPLOYMENT"},
+	}
+
+	d := buildDeployment(1)
+	d.Id = 42
+	d.TargetName = "staging"
+
+	promotion := buildPromotion(d, target, user, "")
+
+	if promotion.PromotedFrom != d.Id {
+		t.Errorf("wrong promoted from. want=%d, got=%d", d.Id, promotion.PromotedFrom)
+	}
+	if promotion.CommitSha != d.CommitSha || promotion.Branch != d.Branch {
+		t.Errorf("wrong commit. want=%s (%s), got=%s (%s)", d.CommitSha, d.Branch,
+			promotion.CommitSha, promotion.Branch)
+	}
+	if promotion.TargetName != target.Name || promotion.UserId != user.Id {
+		t.Errorf("wrong target or user: %+v", promotion)
+	}
+	if len(promotion.Stages) != 1 || promotion.Stages[0] != "CODE_DEPLOYMENT" {
+		t.Errorf("wrong stages. want=%v, got=%v", target.DefaultStages, promotion.Stages)
+	}
+
+	expected := "Promote #42 from staging: Deploying a hotfix"
+	if promotion.Comment != expected {
+		t.Errorf("wrong comment. want=%q, got=%q", expected, promotion.Comment)
+	}
+
+	promotion = buildPromotion(d, target, user, "ship it")
+	if promotion.Comment != "ship it" {
+		t.Errorf("wrong comment. want=%q, got=%q", "ship it", promotion.Comment)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const schedulerSleepTime = 30 * time.Second
+
+// RunScheduledDeployments starts scheduled deployments once they are due.
+func RunScheduledDeployments(db *sql.DB) {
+	for {
+		startDueDeployments(db, time.Now())
+		time.Sleep(schedulerSleepTime)
+	}
+}
+
+func startDueDeployments(db *sql.DB, now time.Time) {
+	deployments, err := getDueScheduledDeployments(db, now)
+	if err != nil {
+		log.Printf("Loading due scheduled deployments failed: %s\n", err)
+		return
+	}
+
+	for _, d := range deployments {
+		application, err := findApplication(d.ApplicationName)
+		if err != nil {
+			log.Printf("Scheduled deployment %d failed: %s\n", d.Id, err)
+			finishDeployment(d, models.DEPLOYMENT_FAILED)
+			continue
+		}
+
+		target, err := findTarget(application, d.TargetName)
+		if err != nil {
+			log.Printf("Scheduled deployment %d failed: %s\n", d.Id, err)
+			finishDeployment(d, models.DEPLOYMENT_FAILED)
+			continue
+		}
+
+		err = checkDueDeployment(application, target, d, now)
+		if err != nil {
+			switch err.(type) {
+			case *FrozenError, *StatusChecksError:
+				log.Printf("Cancelling scheduled deployment %d: %s\n", d.Id, err)
+				cancelDueDeployment(d)
+			default:
+				log.Printf("Checking scheduled deployment %d failed: %s\n", d.Id, err)
+			}
+			continue
+		}
+
+		log.Printf("Starting scheduled deployment %d\n", d.Id)
+
+		err = startScheduledDeployment(application, target, d)
+		if err != nil && err != ErrNotScheduled {
+			log.Printf("Could not start scheduled deployment %d: %s\n", d.Id, err)
+		}
+	}
+}
+
+// checkDueDeployment checks a due scheduled deployment against the freezes
+// and the required status checks again, since they might have changed after
+// the deployment was scheduled. Checks that were overridden when the
+// deployment was scheduled are not checked again.
+func checkDueDeployment(a *models.Application, t *models.Target, d *models.Deployment, now time.Time) error {
+	if d.FreezeOverride == "" {
+		_, err := checkFreeze(a, t, nil, false, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.StatusChecksOverride == "" && len(t.RequiredStatusChecks) > 0 {
+		user, err := getUser(db, d.UserId)
+		if err != nil {
+			return err
+		}
+
+		_, err = checkStatusChecks(a, t, user, d.CommitSha, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cancelDueDeployment cancels a due scheduled deployment that must not run.
+func cancelDueDeployment(d *models.Deployment) {
+	err := cancelDeployment(db, d)
+	if err != nil {
+		if err != ErrNotWaiting {
+			log.Printf("Could not cancel scheduled deployment %d: %s\n", d.Id, err)
+		}
+		return
+	}
+
+	eventHub.Publish(models.DEPLOYMENT_CANCELLED, d)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestStartDueDeploymentsChecksFreezes(t *testing.T) {
+	db = newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	target := &models.Target{Name: "production"}
+	application := &models.Application{Name: "flincOnRails", Targets: []*models.Target{target}}
+	config = &Configuration{Applications: []*models.Application{application}}
+	eventHub = NewDeploymentEventHub(db)
+
+	now := time.Now()
+	dueAt := now.Add(-time.Minute)
+
+	frozen := buildDeployment(user.Id)
+	frozen.ScheduledAt = &dueAt
+	err = createScheduledDeployment(db, frozen)
+	checkErr(t, err)
+
+	freeze := &models.Freeze{
+		ApplicationName: application.Name,
+		TargetName:      target.Name,
+		UserId:          user.Id,
+		Reason:          "incident",
+		ExpiresAt:       now.Add(time.Hour),
+	}
+	err = createFreeze(db, freeze)
+	checkErr(t, err)
+
+	startDueDeployments(db, now)
+
+	frozen, err = getDeployment(db, frozen.Id)
+	checkErr(t, err)
+	if frozen.State != models.DEPLOYMENT_CANCELLED {
+		t.Errorf("wrong state of frozen deployment. want=%s, got=%s", models.DEPLOYMENT_CANCELLED, frozen.State)
+	}
+
+	// Overridden checks are not checked again, so GitHub is not asked for
+	// the status checks
+	target.RequiredStatusChecks = []string{"ci/travis"}
+	overridden := &models.Deployment{FreezeOverride: "hotfix", StatusChecksOverride: "ci/travis (pending)"}
+	err = checkDueDeployment(application, target, overridden, now)
+	if err != nil {
+		t.Errorf("expected overridden checks not to be checked again, got=%s", err)
+	}
+}
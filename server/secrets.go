@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// Nil if no secrets key is configured
+var secretStore *SecretStore
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// SecretStore saves secrets in the database, encrypted with AES-GCM and a key
+// derived from the secrets key of the configuration.
+type SecretStore struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+func NewSecretStore(db *sql.DB, key []byte) (*SecretStore, error) {
+	if len(key) == 0 {
+		return nil, errors.New("secrets key is empty")
+	}
+
+	derived := sha256.Sum256(key)
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretStore{db: db, aead: aead}, nil
+}
+
+// readSecretsKey returns the secrets key from the environment variable or the
+// file of the configuration, nil if neither is configured.
+func readSecretsKey(c *Configuration) ([]byte, error) {
+	if c.SecretsKeyEnv != "" {
+		key := os.Getenv(c.SecretsKeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("environment variable %s with the secrets key is empty", c.SecretsKeyEnv)
+		}
+		return []byte(key), nil
+	}
+
+	if c.SecretsKeyFile != "" {
+		key, err := ioutil.ReadFile(c.SecretsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(key), "\r\n")), nil
+	}
+
+	return nil, nil
+}
+
+// Set saves the value of the secret with the name, replacing its previous
+// value.
+func (s *SecretStore) Set(name, value string, userId int) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+
+	encrypted, err := s.encrypt(value)
+	if err != nil {
+		return err
+	}
+
+	return upsertSecret(s.db, name, encrypted, userId)
+}
+
+// Get returns the decrypted value of the secret with the name.
+func (s *SecretStore) Get(name string) (string, error) {
+	encrypted, err := getSecretValue(s.db, name)
+	if err != nil {
+		return "", err
+	}
+	if encrypted == "" {
+		return "", fmt.Errorf("secret %s not found", name)
+	}
+
+	return s.decrypt(encrypted)
+}
+
+// Delete removes the secret with the name.
+func (s *SecretStore) Delete(name string) error {
+	return deleteSecret(s.db, name)
+}
+
+func (s *SecretStore) encrypt(value string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *SecretStore) decrypt(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	value, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("decrypting secret failed, wrong secrets key?")
+	}
+
+	return string(value), nil
+}
+
+// secretResolver returns the resolver of references to the stored secrets,
+// nil if there is no secret store.
+func secretResolver() models.SecretResolver {
+	if secretStore == nil {
+		return nil
+	}
+	return secretStore.Get
+}
+
+// resolveDeploymentSecrets replaces references to stored secrets in the SSH
+// keys of the deployment config and its jump hosts with the secrets, and
+// makes the stored secrets available to the env of the deployment. The jump
+// hosts and hosts are copied, so the configured target is left as it is.
+func resolveDeploymentSecrets(dc *models.DeploymentConfig) error {
+	dc.Secrets = secretResolver()
+
+	sshKey, err := models.ResolveSecret(string(dc.SshKey), dc.Secrets)
+	if err != nil {
+		return err
+	}
+	dc.SshKey = []byte(sshKey)
+
+	dc.JumpHosts, err = resolveJumpHostSecrets(dc.JumpHosts, dc.Secrets)
+	if err != nil {
+		return err
+	}
+
+	hosts := []*models.Host{}
+	for _, h := range dc.Hosts {
+		resolved := *h
+		resolved.JumpHosts, err = resolveJumpHostSecrets(h.JumpHosts, dc.Secrets)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, &resolved)
+	}
+	dc.Hosts = hosts
+
+	return nil
+}
+
+// resolveJumpHostSecrets returns copies of the jump hosts with references to
+// stored secrets in their SSH keys replaced by the secrets. A nil list stays
+// nil, since it means that a host uses the jump hosts of the target.
+func resolveJumpHostSecrets(jumpHosts []*models.JumpHost, secrets models.SecretResolver) ([]*models.JumpHost, error) {
+	if jumpHosts == nil {
+		return nil, nil
+	}
+
+	resolved := []*models.JumpHost{}
+	for _, j := range jumpHosts {
+		sshKey, err := models.ResolveSecret(j.SshKey, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %s", j.Name, err)
+		}
+
+		jumpHost := *j
+		jumpHost.SshKey = sshKey
+		resolved = append(resolved, &jumpHost)
+	}
+
+	return resolved, nil
+}
+
+// resolveTargetSecrets returns a copy of the target with the references to
+// stored secrets in the settings of the notifiers replaced by the secrets.
+// Settings whose secret can't be resolved are left empty.
+func resolveTargetSecrets(t *models.Target) *models.Target {
+	resolved := *t
+
+	fields := []*string{
+		&resolved.BugsnagApiKey,
+		&resolved.FlowdockEndpoint,
+		&resolved.NewRelicApiKey,
+		&resolved.SlackUrl,
+	}
+	for _, field := range fields {
+		value, err := models.ResolveSecret(*field, secretResolver())
+		if err != nil {
+			log.Printf("Resolving secret of target %s failed: %s\n", t.Name, err)
+		}
+		*field = value
+	}
+
+	return &resolved
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/context"
+)
+
+func TestSecretStore(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	store, err := NewSecretStore(db, []byte("master-key"))
+	checkErr(t, err)
+
+	err = store.Set("prod-ssh-key", "first", user.Id)
+	checkErr(t, err)
+	err = store.Set("prod-ssh-key", "second", user.Id)
+	checkErr(t, err)
+
+	value, err := store.Get("prod-ssh-key")
+	checkErr(t, err)
+	if value != "second" {
+		t.Errorf("wrong secret value. want=%q, got=%q", "second", value)
+	}
+
+	stored, err := getSecretValue(db, "prod-ssh-key")
+	checkErr(t, err)
+	if stored == "second" {
+		t.Errorf("secret stored unencrypted")
+	}
+
+	secrets, err := getSecrets(db)
+	checkErr(t, err)
+	if len(secrets) != 1 || secrets[0].Name != "prod-ssh-key" || secrets[0].User.Name != user.Name {
+		t.Errorf("wrong secrets listed: %+v", secrets)
+	}
+
+	wrongKeyStore, err := NewSecretStore(db, []byte("wrong-key"))
+	checkErr(t, err)
+	_, err = wrongKeyStore.Get("prod-ssh-key")
+	if err == nil {
+		t.Errorf("expected decrypting with the wrong key to fail")
+	}
+
+	err = store.Delete("prod-ssh-key")
+	checkErr(t, err)
+	_, err = store.Get("prod-ssh-key")
+	if err == nil {
+		t.Errorf("expected deleted secret to be missing")
+	}
+
+	err = store.Set("invalid name", "value", user.Id)
+	if err == nil {
+		t.Errorf("expected invalid secret name to be rejected")
+	}
+}
+
+func TestResolveTargetSecrets(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	store, err := NewSecretStore(db, []byte("master-key"))
+	checkErr(t, err)
+	err = store.Set("slack-url", "https://hooks.slack.com/secret", user.Id)
+	checkErr(t, err)
+
+	secretStore = store
+	defer func() { secretStore = nil }()
+
+	target := &models.Target{
+		Name:          "production",
+		SlackUrl:      "secret://slack-url",
+		BugsnagApiKey: "plain-key",
+	}
+
+	resolved := resolveTargetSecrets(target)
+	if resolved.SlackUrl != "https://hooks.slack.com/secret" {
+		t.Errorf("wrong slack url. want=%q, got=%q", "https://hooks.slack.com/secret", resolved.SlackUrl)
+	}
+	if resolved.BugsnagApiKey != "plain-key" {
+		t.Errorf("wrong bugsnag api key. want=%q, got=%q", "plain-key", resolved.BugsnagApiKey)
+	}
+	if target.SlackUrl != "secret://slack-url" {
+		t.Errorf("configured target was modified: %q", target.SlackUrl)
+	}
+
+	dc := &models.DeploymentConfig{SshKey: []byte("secret://slack-url")}
+	err = resolveDeploymentSecrets(dc)
+	checkErr(t, err)
+	if string(dc.SshKey) != "https://hooks.slack.com/secret" || dc.Secrets == nil {
+		t.Errorf("deployment secrets not resolved: %q", dc.SshKey)
+	}
+}
+
+func TestResolveDeploymentSecretsJumpHosts(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "bob")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	store, err := NewSecretStore(db, []byte("master-key"))
+	checkErr(t, err)
+	err = store.Set("bastion-key", "PRIVATE KEY", user.Id)
+	checkErr(t, err)
+
+	secretStore = store
+	defer func() { secretStore = nil }()
+
+	targetJumpHost := &models.JumpHost{Name: "bastion.applikatoni.com", SshKey: "secret://bastion-key"}
+	hostJumpHost := &models.JumpHost{Name: "bastion2.applikatoni.com", SshKey: "secret://bastion-key"}
+	target := &models.Target{
+		Name:      "production",
+		JumpHosts: []*models.JumpHost{targetJumpHost},
+		Hosts: []*models.Host{
+			{Name: "web1.applikatoni.com"},
+			{Name: "web2.applikatoni.com", JumpHosts: []*models.JumpHost{hostJumpHost}},
+			{Name: "web3.applikatoni.com", JumpHosts: []*models.JumpHost{}},
+		},
+	}
+
+	dc := models.NewDeploymentConfig(&models.Deployment{}, target, nil)
+	err = resolveDeploymentSecrets(dc)
+	checkErr(t, err)
+
+	if dc.JumpHosts[0].SshKey != "PRIVATE KEY" {
+		t.Errorf("jump host key of the target not resolved: %q", dc.JumpHosts[0].SshKey)
+	}
+	if dc.Hosts[0].JumpHosts != nil {
+		t.Errorf("expected host without jump hosts to use the target's, got=%v", dc.Hosts[0].JumpHosts)
+	}
+	if dc.Hosts[1].JumpHosts[0].SshKey != "PRIVATE KEY" {
+		t.Errorf("jump host key of the host not resolved: %q", dc.Hosts[1].JumpHosts[0].SshKey)
+	}
+	if dc.Hosts[2].JumpHosts == nil || len(dc.Hosts[2].JumpHosts) != 0 {
+		t.Errorf("expected host to stay reachable directly, got=%v", dc.Hosts[2].JumpHosts)
+	}
+	if targetJumpHost.SshKey != "secret://bastion-key" || hostJumpHost.SshKey != "secret://bastion-key" {
+		t.Errorf("configured jump hosts were modified")
+	}
+
+	target.JumpHosts = []*models.JumpHost{{Name: "bastion.applikatoni.com", SshKey: "secret://missing"}}
+	dc = models.NewDeploymentConfig(&models.Deployment{}, target, nil)
+	err = resolveDeploymentSecrets(dc)
+	if err == nil {
+		t.Errorf("expected an error for a missing jump host secret")
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	config = &Configuration{AdminUsernames: []string{"alice"}}
+
+	tests := []struct {
+		user     *models.User
+		expected int
+	}{
+		{nil, http.StatusFound},
+		{buildUser(1, "bob"), http.StatusForbidden},
+		{buildUser(2, "alice"), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/secrets", nil)
+		if tt.user != nil {
+			context.Set(r, CurrentUser, tt.user)
+		}
+		rec := httptest.NewRecorder()
+
+		requireAdmin(func(w http.ResponseWriter, r *http.Request) {})(rec, r)
+		context.Clear(r)
+
+		if rec.Code != tt.expected {
+			t.Errorf("wrong status code for %+v. want=%d, got=%d", tt.user, tt.expected, rec.Code)
+		}
+	}
+}
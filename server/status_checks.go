@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// StatusChecksError is returned when a deployment is created for a commit
+// whose required status checks aren't passing.
+type StatusChecksError struct {
+	Failing []string
+}
+
+func (e *StatusChecksError) Error() string {
+	return fmt.Sprintf("required status checks are not passing: %s",
+		strings.Join(e.Failing, ", "))
+}
+
+// failingStatusChecks returns the required checks that are not successful,
+// together with their state, e.g. "ci/travis (failure)". Checks that haven't
+// reported a state are "missing".
+func failingStatusChecks(required []string, states map[string]string) []string {
+	failing := []string{}
+
+	for _, name := range required {
+		state, ok := states[name]
+		if !ok {
+			state = "missing"
+		}
+		if state != "success" {
+			failing = append(failing, fmt.Sprintf("%s (%s)", name, state))
+		}
+	}
+
+	return failing
+}
+
+// checkStatusChecks returns a *StatusChecksError if the required status checks
+// of the target aren't passing for the commit on GitHub, unless the user wants
+// to override them. In that case the failing checks are returned, so they can
+// be saved with the deployment.
+func checkStatusChecks(a *models.Application, t *models.Target, u *models.User, commitSha string, override bool) (string, error) {
+	if len(t.RequiredStatusChecks) == 0 {
+		return "", nil
+	}
+
+	states, err := NewGitHubClient(u).GetCommitChecks(a, commitSha)
+	if err != nil {
+		return "", err
+	}
+
+	failing := failingStatusChecks(t.RequiredStatusChecks, states)
+	if len(failing) == 0 {
+		return "", nil
+	}
+
+	if override {
+		return strings.Join(failing, ", "), nil
+	}
+	return "", &StatusChecksError{Failing: failing}
+}
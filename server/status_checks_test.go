@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFailingStatusChecks(t *testing.T) {
+	states := map[string]string{
+		"ci/travis": "success",
+		"lint":      "failure",
+		"coverage":  "pending",
+	}
+
+	tests := []struct {
+		required []string
+		expected []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"ci/travis"}, []string{}},
+		{[]string{"ci/travis", "lint"}, []string{"lint (failure)"}},
+		{[]string{"coverage", "security"}, []string{"coverage (pending)", "security (missing)"}},
+	}
+
+	for i, tt := range tests {
+		failing := failingStatusChecks(tt.required, states)
+		if !reflect.DeepEqual(failing, tt.expected) {
+			t.Errorf("%d: wrong failing checks. want=%v, got=%v", i, tt.expected, failing)
+		}
+	}
+}
+
+func TestStatusChecksOverrideSaved(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	d := buildDeployment(1)
+	d.StatusChecksOverride = "lint (failure)"
+	err := createDeployment(db, d)
+	checkErr(t, err)
+
+	saved, err := getDeployment(db, d.Id)
+	checkErr(t, err)
+
+	if saved.StatusChecksOverride != d.StatusChecksOverride {
+		t.Errorf("wrong status checks override. want=%q, got=%q", d.StatusChecksOverride, saved.StatusChecksOverride)
+	}
+}
@@ -39,6 +39,22 @@ func fmtDeploymentState(state models.DeploymentState) template.HTML {
 		s = `<span data-attr="state-info" class="label label-success">Successful</span>`
 	case models.DEPLOYMENT_FAILED:
 		s = `<span data-attr="state-info" class="label label-danger">Failed</span>`
+	case models.DEPLOYMENT_ROLLED_BACK:
+		s = `<span data-attr="state-info" class="label label-warning">Rolled back</span>`
+	case models.DEPLOYMENT_KILLED:
+		s = `<span data-attr="state-info" class="label label-danger">Killed</span>`
+	case models.DEPLOYMENT_QUEUED:
+		s = `<span data-attr="state-info" class="label label-default">Queued</span>`
+	case models.DEPLOYMENT_CANCELLED:
+		s = `<span data-attr="state-info" class="label label-default">Cancelled</span>`
+	case models.DEPLOYMENT_PENDING_APPROVAL:
+		s = `<span data-attr="state-info" class="label label-warning">Pending approval</span>`
+	case models.DEPLOYMENT_REJECTED:
+		s = `<span data-attr="state-info" class="label label-danger">Rejected</span>`
+	case models.DEPLOYMENT_SCHEDULED:
+		s = `<span data-attr="state-info" class="label label-default">Scheduled</span>`
+	case models.DEPLOYMENT_PARTIALLY_SUCCESSFUL:
+		s = `<span data-attr="state-info" class="label label-warning">Partially successful</span>`
 	}
 
 	return template.HTML(s)
@@ -17,16 +17,19 @@ type WebhookApplication struct {
 }
 
 type WebhookDeployment struct {
-	Id             int                    `json:"deployment_id"`
-	CommitSha      string                 `json:"commit_sha"`
-	Branch         string                 `json:"branch"`
-	State          models.DeploymentState `json:"state"`
-	Comment        string                 `json:"comment"`
-	CreatedAt      time.Time              `json:"created_at"`
-	URL            string                 `json:"deployment_url"`
-	DeployerID     int                    `json:"deployer_id"`
-	DeployerName   string                 `json:"deployer_name"`
-	DeployerAvatar string                 `json:"deployer_avatar"`
+	Id             int                      `json:"deployment_id"`
+	CommitSha      string                   `json:"commit_sha"`
+	Branch         string                   `json:"branch"`
+	State          models.DeploymentState   `json:"state"`
+	Stages         []models.DeploymentStage `json:"stages"`
+	Comment        string                   `json:"comment"`
+	CreatedAt      time.Time                `json:"created_at"`
+	ScheduledAt    *time.Time               `json:"scheduled_at,omitempty"`
+	FailedHosts    []string                 `json:"failed_hosts,omitempty"`
+	URL            string                   `json:"deployment_url"`
+	DeployerID     int                      `json:"deployer_id"`
+	DeployerName   string                   `json:"deployer_name"`
+	DeployerAvatar string                   `json:"deployer_avatar"`
 }
 
 type WebhookTarget struct {
@@ -39,6 +42,13 @@ type WebhookTarget struct {
 	DefaultStages   []models.DeploymentStage `json:"default_stages"`
 }
 
+type WebhookApproval struct {
+	ApproverID   int    `json:"approver_id"`
+	ApproverName string `json:"approver_name"`
+	Approved     bool   `json:"approved"`
+	Comment      string `json:"comment"`
+}
+
 type WebhookMsg struct {
 	Timestamp time.Time              `json:"timestamp"`
 	State     models.DeploymentState `json:"state"`
@@ -46,6 +56,7 @@ type WebhookMsg struct {
 	Application WebhookApplication `json:"application"`
 	Deployment  WebhookDeployment  `json:"deployment"`
 	Target      WebhookTarget      `json:"target"`
+	Approval    *WebhookApproval   `json:"approval,omitempty"`
 }
 
 func NotifyWebhooks(ev *DeploymentEvent) {
@@ -66,8 +77,11 @@ func NotifyWebhooks(ev *DeploymentEvent) {
 			CommitSha:      ev.Deployment.CommitSha,
 			Branch:         ev.Deployment.Branch,
 			State:          ev.Deployment.State,
+			Stages:         ev.Deployment.Stages,
 			Comment:        ev.Deployment.Comment,
 			CreatedAt:      ev.Deployment.CreatedAt,
+			ScheduledAt:    ev.Deployment.ScheduledAt,
+			FailedHosts:    ev.Deployment.FailedHosts,
 			URL:            ev.DeploymentURL(),
 			DeployerID:     ev.Deployment.UserId,
 			DeployerName:   ev.Deployment.User.Name,
@@ -77,13 +91,24 @@ func NotifyWebhooks(ev *DeploymentEvent) {
 			Name:            ev.Target.Name,
 			DeploymentUser:  ev.Target.DeploymentUser,
 			DeployUsernames: ev.Target.DeployUsernames,
-			Hosts:           ev.Target.Hosts,
-			Roles:           ev.Target.Roles,
+			Hosts:           webhookHosts(ev.Target.Hosts),
+			Roles:           webhookRoles(ev.Target.Roles),
 			AvailableStages: ev.Target.AvailableStages,
 			DefaultStages:   ev.Target.DefaultStages,
 		},
 	}
 
+	if ev.Approval != nil {
+		msg.Approval = &WebhookApproval{
+			ApproverID: ev.Approval.UserId,
+			Approved:   ev.Approval.Approved,
+			Comment:    ev.Approval.Comment,
+		}
+		if ev.Approval.User != nil {
+			msg.Approval.ApproverName = ev.Approval.User.Name
+		}
+	}
+
 	for _, w := range ev.Target.Webhooks {
 		go sendWebhookMsg(w, msg)
 	}
@@ -106,3 +131,37 @@ func sendWebhookMsg(hook string, msg WebhookMsg) {
 	log.Printf("Notified Webhook %s about deployment of %v on %v! Response: %v",
 		hook, msg.Application.Name, msg.Target.Name, resp.Status)
 }
+
+// webhookHosts returns copies of the hosts without the SSH keys of their
+// jump hosts
+func webhookHosts(hosts []*models.Host) []*models.Host {
+	copies := make([]*models.Host, len(hosts))
+
+	for i, h := range hosts {
+		c := *h
+		if h.JumpHosts != nil {
+			c.JumpHosts = make([]*models.JumpHost, len(h.JumpHosts))
+			for j, jh := range h.JumpHosts {
+				c.JumpHosts[j] = &models.JumpHost{Name: jh.Name, User: jh.User}
+			}
+		}
+		copies[i] = &c
+	}
+
+	return copies
+}
+
+// webhookRoles returns copies of the roles with the values of their env and
+// of their secret options masked, since they can contain secrets.
+func webhookRoles(roles []*models.Role) []*models.Role {
+	copies := make([]*models.Role, len(roles))
+
+	for i, r := range roles {
+		c := *r
+		c.Env = models.MaskEnv(r.Env)
+		c.Options = r.MaskedOptions()
+		copies[i] = &c
+	}
+
+	return copies
+}
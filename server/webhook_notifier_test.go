@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 )
@@ -37,6 +39,9 @@ func TestNotifyWebhooks(t *testing.T) {
 		if msg.State != event.State {
 			t.Errorf("wrong message state. got=%s", msg.State)
 		}
+		if len(msg.Deployment.Stages) != len(deployment.Stages) {
+			t.Errorf("wrong deployment stages. want=%v, got=%v", deployment.Stages, msg.Deployment.Stages)
+		}
 	}
 
 	firstWebhook := httptest.NewServer(http.HandlerFunc(testHandler))
@@ -48,3 +53,176 @@ func TestNotifyWebhooks(t *testing.T) {
 
 	NotifyWebhooks(event)
 }
+
+func TestNotifyWebhooksWithApproval(t *testing.T) {
+	target := &models.Target{Name: "production"}
+	application := &models.Application{GitHubOwner: "shipping-co", GitHubRepo: "main-web-app"}
+
+	user := buildUser(1234, "Bobby")
+	approver := buildUser(5678, "Alice")
+	deployment := buildDeployment(user.Id)
+	deployment.User = user
+
+	event := &DeploymentEvent{
+		State:       models.DEPLOYMENT_REJECTED,
+		Deployment:  deployment,
+		Application: application,
+		Target:      target,
+		User:        user,
+		Approval:    &models.Approval{UserId: approver.Id, User: approver, Comment: "not today"},
+	}
+
+	received := make(chan *WebhookMsg, 1)
+	testHandler := func(w http.ResponseWriter, r *http.Request) {
+		msg := &WebhookMsg{}
+		err := json.NewDecoder(r.Body).Decode(msg)
+		if err != nil {
+			t.Errorf("decoding failed: %s", err)
+		}
+		received <- msg
+	}
+
+	webhook := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer webhook.Close()
+
+	target.Webhooks = []string{webhook.URL}
+
+	NotifyWebhooks(event)
+
+	select {
+	case msg := <-received:
+		if msg.Approval == nil {
+			t.Fatalf("approval missing in message")
+		}
+		if msg.Approval.ApproverName != approver.Name || msg.Approval.Approved {
+			t.Errorf("wrong approval in message: %+v", msg.Approval)
+		}
+		if msg.Approval.Comment != "not today" {
+			t.Errorf("wrong approval comment. want=%q, got=%q", "not today", msg.Approval.Comment)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("webhook not notified")
+	}
+}
+
+func TestNotifyWebhooksWithFailedHosts(t *testing.T) {
+	target := &models.Target{Name: "production"}
+	application := &models.Application{GitHubOwner: "shipping-co", GitHubRepo: "main-web-app"}
+
+	user := buildUser(1234, "Bobby")
+	deployment := buildDeployment(user.Id)
+	deployment.User = user
+	deployment.FailedHosts = []string{"web2.applikatoni.com:22"}
+
+	event := &DeploymentEvent{
+		State:       models.DEPLOYMENT_PARTIALLY_SUCCESSFUL,
+		Deployment:  deployment,
+		Application: application,
+		Target:      target,
+		User:        user,
+	}
+
+	received := make(chan *WebhookMsg, 1)
+	testHandler := func(w http.ResponseWriter, r *http.Request) {
+		msg := &WebhookMsg{}
+		err := json.NewDecoder(r.Body).Decode(msg)
+		if err != nil {
+			t.Errorf("decoding failed: %s", err)
+		}
+		received <- msg
+	}
+
+	webhook := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer webhook.Close()
+
+	target.Webhooks = []string{webhook.URL}
+
+	NotifyWebhooks(event)
+
+	select {
+	case msg := <-received:
+		if msg.State != models.DEPLOYMENT_PARTIALLY_SUCCESSFUL {
+			t.Errorf("wrong message state. got=%s", msg.State)
+		}
+		if len(msg.Deployment.FailedHosts) != 1 || msg.Deployment.FailedHosts[0] != "web2.applikatoni.com:22" {
+			t.Errorf("wrong failed hosts in message: %v", msg.Deployment.FailedHosts)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("webhook not notified")
+	}
+}
+
+func TestWebhookHostsStripsJumpHostKeys(t *testing.T) {
+	hosts := []*models.Host{
+		{Name: "web.applikatoni.com:22", Roles: []string{"web"}},
+		{
+			Name:      "db.applikatoni.com:22",
+			Roles:     []string{"database"},
+			JumpHosts: []*models.JumpHost{{Name: "bastion.applikatoni.com:22", User: "jump", SshKey: "SECRET"}},
+		},
+	}
+
+	copies := webhookHosts(hosts)
+	if len(copies) != len(hosts) {
+		t.Fatalf("wrong number of hosts. want=%d, got=%d", len(hosts), len(copies))
+	}
+
+	jumpHost := copies[1].JumpHosts[0]
+	if jumpHost.SshKey != "" {
+		t.Errorf("jump host ssh key not stripped. got=%s", jumpHost.SshKey)
+	}
+	if jumpHost.Name != "bastion.applikatoni.com:22" || jumpHost.User != "jump" {
+		t.Errorf("wrong jump host. got=%+v", jumpHost)
+	}
+	if hosts[1].JumpHosts[0].SshKey != "SECRET" {
+		t.Errorf("original jump host was modified")
+	}
+}
+
+func TestWebhookRolesMasksEnv(t *testing.T) {
+	roles := []*models.Role{
+		{Name: "web", Env: map[string]string{"DATABASE_PASSWORD": "env://DB_PASSWORD", "RAILS_ENV": "production"}},
+		{Name: "workers"},
+	}
+
+	copies := webhookRoles(roles)
+	if len(copies) != len(roles) {
+		t.Fatalf("wrong number of roles. want=%d, got=%d", len(roles), len(copies))
+	}
+
+	for name, value := range copies[0].Env {
+		if value != models.MaskedValue {
+			t.Errorf("env variable %s not masked. got=%s", name, value)
+		}
+	}
+	if copies[1].Env != nil {
+		t.Errorf("expected no env, got=%v", copies[1].Env)
+	}
+	if roles[0].Env["RAILS_ENV"] != "production" {
+		t.Errorf("original role was modified")
+	}
+}
+
+func TestWebhookRolesMasksSecretOptions(t *testing.T) {
+	roles := []*models.Role{
+		{
+			Name:          "web",
+			Options:       map[string]string{"Dir": "/var/www", "ApiToken": "s3cr3t"},
+			SecretOptions: []string{"ApiToken", "NotSet"},
+		},
+		{Name: "workers"},
+	}
+
+	copies := webhookRoles(roles)
+
+	expected := map[string]string{"Dir": "/var/www", "ApiToken": models.MaskedValue}
+	if !reflect.DeepEqual(copies[0].Options, expected) {
+		t.Errorf("wrong options. want=%v, got=%v", expected, copies[0].Options)
+	}
+	if copies[1].Options != nil {
+		t.Errorf("expected no options, got=%v", copies[1].Options)
+	}
+	if roles[0].Options["ApiToken"] != "s3cr3t" {
+		t.Errorf("original role was modified")
+	}
+}